@@ -2,17 +2,42 @@ package funnydb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/funny/funnydb-go-sdk/v2/internal"
 	"github.com/google/uuid"
 )
 
+var ErrSendingPauseUnsupported = errors.New("pause sending is only supported in async mode")
+var ErrRateLimitUnsupported = errors.New("rate limit is only supported in simple and async mode")
+
+// SendingStatus 发送状态，见 Client.SendingStatus
+type SendingStatus = internal.SendingStatus
+
 type Client struct {
-	p      internal.Producer
-	config *Config
-	stat   *StatCollector
+	p                internal.Producer
+	destinations     map[string]internal.Producer // Config.Destinations 中各目标的 producer
+	destinationStats map[string]*StatCollector    // 各目标的统计，使用目标的访问 key 上报
+	config           *Config
+	stat             *StatCollector
+	statLoop         *statLoop               // 定时上报 stat 与 destinationStats，不上报统计时为 nil
+	scheduler        *internal.SendScheduler // Config 本身的目标与各路由目标共用的发送调度
+
+	superMu           sync.RWMutex
+	superProps        map[string]interface{} // 只会整体替换，读取后不需要加锁
+	dynamicSuperProps DynamicSuperPropertiesFunc
+
+	middlewareMu sync.RWMutex
+	middlewares  []Middleware
+	handler      Handler // middlewares 包装后的处理函数，没有中间件时为 nil
+
+	reservedWarned sync.Map // 已经打印过警告的 # 开头字段
+
+	schema atomic.Pointer[Schema]
 }
 
 func NewClient(config *Config) (*Client, error) {
@@ -22,84 +47,389 @@ func NewClient(config *Config) (*Client, error) {
 		return nil, e
 	}
 
-	var p internal.Producer
+	instanceID := uuid.Must(uuid.NewV7()).String()
+
+	stat, e := newClientStat(instanceID, config)
+	if e != nil {
+		return nil, e
+	}
+
+	c := &Client{
+		config:           config,
+		destinations:     make(map[string]internal.Producer),
+		destinationStats: make(map[string]*StatCollector),
+		stat:             stat,
+	}
+	if config.Mode == ModeSimple || config.Mode == ModeAsync {
+		c.scheduler = internal.NewSendScheduler()
+	}
+	c.SetSuperProperties(config.SuperProperties)
+	c.dynamicSuperProps = config.DynamicSuperProperties
+	c.Use(config.Middlewares...)
+	c.schema.Store(config.Schema)
+
+	p, e := c.newProducer(config, stat)
+	if e != nil {
+		internal.DefaultLogger.Errorf("create sdk client error : %s", e)
+		c.closeScheduler()
+		return nil, e
+	}
+	for _, d := range config.Destinations {
+		dp, dstat, err := c.newDestination(instanceID, config.destinationConfig(d))
+		if err != nil {
+			internal.DefaultLogger.Errorf("create sdk client destination %s error : %s", d.Name, err)
+			p.Close(context.Background())
+			for _, dp := range c.destinations {
+				dp.Close(context.Background())
+			}
+			c.closeScheduler()
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		c.destinations[d.Name] = dp
+		if dstat != nil {
+			c.destinationStats[d.Name] = dstat
+		}
+	}
+
+	c.p = p
+	var collectors []*StatCollector
+	if stat != nil {
+		stat.producer = p
+		collectors = append(collectors, stat)
+	}
+	for _, d := range config.Destinations {
+		if dstat, ok := c.destinationStats[d.Name]; ok {
+			dstat.producer = c.destinations[d.Name]
+			collectors = append(collectors, dstat)
+		}
+	}
+	if len(collectors) > 0 {
+		c.statLoop = startStatLoop(statsReportInterval, collectors)
+	}
+	return c, nil
+}
+
+// closeScheduler 在所有 producer 关闭后停止发送调度
+func (c *Client) closeScheduler() {
+	if c.scheduler != nil {
+		c.scheduler.Close()
+	}
+}
+
+// newClientStat 创建按 config 的访问 key 上报的统计，不上报统计时返回 nil
+func newClientStat(instanceID string, config *Config) (*StatCollector, error) {
+	if config.DisableReportStats {
+		return nil, nil
+	}
+	stat, err := newStatCollector(instanceID, config.Hostname, config.Mode, config.AccessKey, statsReportInterval)
+	if err != nil {
+		return nil, fmt.Errorf("create stat collector error: %s", err)
+	}
+	return stat, nil
+}
+
+// newDestination 创建路由目标的 producer 以及统计，目标的数据与统计都由该 producer 发送，
+// 发送与统计的上报分别由 Client 共用的调度协程与统计协程调度
+func (c *Client) newDestination(instanceID string, config *Config) (internal.Producer, *StatCollector, error) {
+	stat, err := newClientStat(instanceID, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := c.newProducer(config, stat)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, stat, nil
+}
 
+// newProducer 创建 config 对应的 producer，丢弃的数据计入 stat
+func (c *Client) newProducer(config *Config, stat *StatCollector) (internal.Producer, error) {
+	onDiscard := func(record DiscardedRecord) {
+		c.onDiscard(stat, record)
+	}
 	switch config.Mode {
 	case ModeNoop:
-		p, e = internal.NewNoopProducer()
+		return internal.NewNoopProducer()
 	case ModeDebug:
-		p, e = internal.NewConsoleProducer()
+		return internal.NewConsoleProducer()
 	case ModeSimple:
-		p, e = internal.NewIngestProducer(*config.generateIngestProducerConfig())
+		ingestConfig := config.generateIngestProducerConfig()
+		ingestConfig.OnDiscard = onDiscard
+		ingestConfig.Scheduler = c.scheduler
+		return internal.NewIngestProducer(*ingestConfig)
 	case ModePersistOnly:
-		p, e = internal.NewLogProducer(*config.generateLogProducerConfig())
+		return internal.NewLogProducer(*config.generateLogProducerConfig())
 	case ModeAsync:
-		p, e = internal.NewAsyncProducer(*config.generateAsyncProducerConfig())
+		asyncConfig := config.generateAsyncProducerConfig()
+		asyncConfig.OnDiscard = onDiscard
+		asyncConfig.Scheduler = c.scheduler
+		return internal.NewAsyncProducer(*asyncConfig)
 	default:
 		return nil, ErrUnknownProducerType
 	}
+}
 
-	if e != nil {
-		internal.DefaultLogger.Errorf("create sdk client error : %s", e)
-		return nil, e
+// onDiscard 统计 producer 未发送而丢弃的数据
+func (c *Client) onDiscard(stat *StatCollector, record DiscardedRecord) {
+	if stat != nil && record.Name != statsEventName {
+		event := record.Name
+		if record.Type != internal.EventTypeValue {
+			event = record.Type
+		}
+		stat.CollectDiscarded(record.Time, event, record.Reason)
+	}
+	if c.config.OnDiscard != nil {
+		c.config.OnDiscard(record)
 	}
+}
 
-	instanceID := uuid.Must(uuid.NewV7()).String()
+func (c *Client) ReportEvent(ctx context.Context, e *Event) error {
+	return c.handle(ctx, &Record{Event: e})
+}
 
-	var stat *StatCollector
-	if !config.DisableReportStats {
-		var err error
-		stat, err = newStatCollector(p, instanceID, config.Hostname, config.Mode, config.AccessKey, 30*time.Second)
-		if err != nil {
-			return nil, fmt.Errorf("create stat collector error: %s", err)
-		}
+func (c *Client) reportEvent(ctx context.Context, e *Event) error {
+	data, err := c.prepareEvent(ctx, e)
+	if err != nil {
+		return err
 	}
-
-	return &Client{p: p, config: config, stat: stat}, nil
+	t := reportTime(data)
+	p, stat := c.producerFor(true, e.Name, e.RoutingKey)
+	if stat != nil {
+		stat.Collect(t, e.Name)
+	}
+	err = p.Add(internal.ContextWithLane(ctx, e.Priority), data)
+	if err != nil {
+		return fmt.Errorf("ReportEvent: %s, event=%s time=%s", err, e.Name, t)
+	}
+	return nil
 }
 
-func (c *Client) ReportEvent(ctx context.Context, e *Event) error {
+// prepareEvent 校验事件并转换为上报的数据
+func (c *Client) prepareEvent(ctx context.Context, e *Event) (map[string]interface{}, error) {
 	err := e.checkData()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	superProps := c.superPropsFor(ctx, e)
+	if err := c.checkReservedFields(true, superProps); err != nil {
+		return nil, err
 	}
-	data, err := e.transformToReportableData(c.config.Hostname)
+	if err := c.checkReservedFields(true, e.Props); err != nil {
+		return nil, err
+	}
+	data, err := e.transformToReportableData(c.config.Hostname, superProps)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	props := data["data"].(map[string]interface{})
+	if err := c.normalizeProps(props); err != nil {
+		return nil, err
+	}
+	if err := c.validateSchema(true, e.Name, props); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReportEvents 批量上报事件，任意一条事件校验失败时整批都不会上报。
+// ModeAsync 下整批数据只写入一次磁盘队列，适合高吞吐场景。
+//
+// 设置了中间件时每条事件分别经过中间件，中间件调用 next 得到的是校验结果，
+// 所有事件都经过中间件且校验通过后（被丢弃的除外）才整批写入，写入的结果由 ReportEvents 返回
+func (c *Client) ReportEvents(ctx context.Context, events []*Event) error {
+	reported := make([]*Event, 0, len(events))
+	batch := make([]map[string]interface{}, 0, len(events))
+	prepare := func(ctx context.Context, r *Record) error {
+		if r.Event == nil {
+			return ErrRecordNotEvent
+		}
+		data, err := c.prepareEvent(ctx, r.Event)
+		if err != nil {
+			return err
+		}
+		reported = append(reported, r.Event)
+		batch = append(batch, data)
+		return nil
+	}
+
+	h := c.chain(prepare)
+	for i, e := range events {
+		if err := h(ctx, &Record{Event: e}); err != nil {
+			return fmt.Errorf("ReportEvents: events[%d]: %w", i, err)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
 	}
-	if c.stat != nil {
-		c.stat.Collect(e.Time, e.Name)
+
+	producers := make([]internal.Producer, len(reported))
+	for i, e := range reported {
+		p, stat := c.producerFor(true, e.Name, e.RoutingKey)
+		if stat != nil {
+			stat.Collect(reportTime(batch[i]), e.Name)
+		}
+		producers[i] = p
+	}
+
+	var err error
+	if _, ok := c.p.(internal.BatchProducer); ok {
+		// 不同目标、不同优先级的事件分别写入
+		type group struct {
+			p        internal.Producer
+			priority Priority
+		}
+		var keys []group
+		groups := make(map[group][]map[string]interface{})
+		for i, e := range reported {
+			key := group{producers[i], e.Priority}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], batch[i])
+		}
+		for _, key := range keys {
+			err = key.p.(internal.BatchProducer).AddBatch(internal.ContextWithLane(ctx, key.priority), groups[key])
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		for i, data := range batch {
+			err = producers[i].Add(internal.ContextWithLane(ctx, reported[i].Priority), data)
+			if err != nil {
+				break
+			}
+		}
 	}
-	err = c.p.Add(ctx, data)
 	if err != nil {
-		return fmt.Errorf("ReportEvent: %s, event=%s time=%s", err, e.Name, e.Time)
+		return fmt.Errorf("ReportEvents: %s, events=%d", err, len(batch))
 	}
 	return nil
 }
 
 func (c *Client) ReportMutation(ctx context.Context, m *Mutation) error {
+	return c.handle(ctx, &Record{Mutation: m})
+}
+
+func (c *Client) reportMutation(ctx context.Context, m *Mutation) error {
 	err := m.checkData()
 	if err != nil {
 		return err
 	}
+	if err := c.checkReservedFields(false, m.Props); err != nil {
+		return err
+	}
 	data, err := m.transformToReportableData(c.config.Hostname)
 	if err != nil {
 		return err
 	}
-	if c.stat != nil {
-		c.stat.Collect(m.Time, m.Type)
+	props := data["data"].(map[string]interface{})[internal.DataFieldNameProperties].(map[string]interface{})
+	if err := c.normalizeProps(props); err != nil {
+		return err
+	}
+	if err := c.validateSchema(false, m.Type, props); err != nil {
+		return err
 	}
-	err = c.p.Add(ctx, data)
+	t := reportTime(data)
+	p, stat := c.producerFor(false, m.Type, m.RoutingKey)
+	if stat != nil {
+		stat.Collect(t, m.Type)
+	}
+	err = p.Add(ctx, data)
 	if err != nil {
-		return fmt.Errorf("ReportMutation: %s, identity=%s time=%s", err, m.Identity, m.Time)
+		return fmt.Errorf("ReportMutation: %s, identity=%s time=%s", err, m.Identity, t)
+	}
+	return nil
+}
+
+// reportTime 返回上报数据的 #time，Event 与 Mutation 没有设置 Time 时为转换时的当前时间
+func reportTime(data map[string]interface{}) time.Time {
+	ms, _ := data["data"].(map[string]interface{})[internal.DataFieldNameTime].(int64)
+	return time.UnixMilli(ms)
+}
+
+// PauseSending 暂停 async 模式向 ingest 发送数据，期间上报的数据仍然写入磁盘队列，
+// 调用 ResumeSending 后继续发送。重复调用没有影响，暂停状态不会在重启后保留
+func (c *Client) PauseSending() error {
+	if _, ok := c.p.(internal.PausableProducer); !ok {
+		return ErrSendingPauseUnsupported
+	}
+	for _, p := range c.producers() {
+		p.(internal.PausableProducer).PauseSending()
 	}
 	return nil
 }
 
+// ResumeSending 恢复 PauseSending 暂停的发送
+func (c *Client) ResumeSending() error {
+	if _, ok := c.p.(internal.PausableProducer); !ok {
+		return ErrSendingPauseUnsupported
+	}
+	for _, p := range c.producers() {
+		p.(internal.PausableProducer).ResumeSending()
+	}
+	return nil
+}
+
+// SendingStatus 返回是否暂停发送，以及等待发送的数据条数（包括所有路由目标）
+func (c *Client) SendingStatus() (SendingStatus, error) {
+	pp, ok := c.p.(internal.PausableProducer)
+	if !ok {
+		return SendingStatus{}, ErrSendingPauseUnsupported
+	}
+	status := pp.SendingStatus()
+	for _, d := range c.config.Destinations {
+		status.Pending += c.destinations[d.Name].(internal.PausableProducer).SendingStatus().Pending
+	}
+	return status, nil
+}
+
+// SetRateLimit 调整向 ingest 发送的限速，各字段为 0 时不限制。每个路由目标分别按该设置限速
+func (c *Client) SetRateLimit(limit RateLimit) error {
+	if _, ok := c.p.(internal.RateLimitedProducer); !ok {
+		return ErrRateLimitUnsupported
+	}
+	if limit.BytesPerSecond < 0 || limit.RequestsPerSecond < 0 {
+		return ErrConfigRateLimitIllegal
+	}
+	for _, p := range c.producers() {
+		p.(internal.RateLimitedProducer).SetRateLimit(limit)
+	}
+	return nil
+}
+
+// ThrottleStatus 返回当前的限速设置，以及因限速而等待的次数与时长（包括所有路由目标）
+func (c *Client) ThrottleStatus() (ThrottleStatus, error) {
+	rp, ok := c.p.(internal.RateLimitedProducer)
+	if !ok {
+		return ThrottleStatus{}, ErrRateLimitUnsupported
+	}
+	status := rp.ThrottleStatus()
+	for _, d := range c.config.Destinations {
+		ds := c.destinations[d.Name].(internal.RateLimitedProducer).ThrottleStatus()
+		status.Throttling = status.Throttling || ds.Throttling
+		status.ThrottledTimes += ds.ThrottledTimes
+		status.ThrottledDuration += ds.ThrottledDuration
+	}
+	return status, nil
+}
+
 func (c *Client) Close(ctx context.Context) error {
-	c.stat.Close()
+	if c.statLoop != nil {
+		c.statLoop.Close()
+	}
 
-	err := c.p.Close(ctx)
+	var errs []error
+	for _, d := range c.config.Destinations {
+		if err := c.destinations[d.Name].Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", d.Name, err))
+		}
+	}
+	if err := c.p.Close(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	c.closeScheduler()
+	err := errors.Join(errs...)
 	if err != nil {
 		internal.DefaultLogger.Errorf("close client error: %s", err)
 	} else {
@@ -1,12 +1,16 @@
 package funnydb
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +18,7 @@ import (
 	client "github.com/funny/ingest-client-go-sdk/v2"
 	"github.com/h2non/gock"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -111,6 +116,561 @@ func TestAsyncClient(t *testing.T) {
 	c.Close(context.Background())
 }
 
+// 测试批量上报
+func TestAsyncClientReportEvents(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	c, err := createClient(tmpDir)
+	assert.Nil(t, err)
+
+	createGockReq().
+		SetMatcher(doubleMessageMatcher).
+		Times(1).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	events := []*Event{
+		{Name: "UserLogin", Props: map[string]interface{}{"#account_id": "account-1"}},
+		{Name: "UserLogout", Props: map[string]interface{}{"#account_id": "account-1"}},
+	}
+	err = c.ReportEvents(context.Background(), events)
+	assert.Nil(t, err)
+
+	waitingForResponse()
+
+	// 任意一条校验失败时整批不上报
+	err = c.ReportEvents(context.Background(), []*Event{{Name: "UserLogin", Props: map[string]interface{}{}}, {}})
+	assert.ErrorIs(t, err, ErrEventDataNameIllegal)
+
+	c.Close(context.Background())
+}
+
+// 测试磁盘队列开启压缩
+func TestAsyncClientQueueCompression(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Mode:             ModeAsync,
+		IngestEndpoint:   "http://ingest.com",
+		SendTimeout:      5 * time.Second,
+		AccessKey:        "demo",
+		AccessSecret:     "demo",
+		Directory:        tmpDir,
+		QueueCompression: "lz4",
+	}
+	_, err = NewClient(config)
+	assert.ErrorIs(t, err, ErrConfigQueueCompressionIllegal)
+
+	config.QueueCompression = "zstd"
+	c, err := NewClient(config)
+	assert.Nil(t, err)
+
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Times(1).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	err = c.ReportEvent(context.Background(), userLoginEvent)
+	assert.Nil(t, err)
+
+	waitingForResponse()
+
+	c.Close(context.Background())
+}
+
+// 测试落盘数据加密
+func TestClientEncryption(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-encryption-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	provider := NewStaticKeyProvider("k1", []byte("0123456789abcdef0123456789abcdef"))
+
+	_, err = NewClient(&Config{
+		Mode:                  ModePersistOnly,
+		Directory:             filepath.Join(tmpDir, "invalid"),
+		EncryptionKeyProvider: NewStaticKeyProvider("k1", []byte("short")),
+	})
+	assert.NotNil(t, err)
+
+	persistDir := filepath.Join(tmpDir, "persist")
+	c, err := NewClient(&Config{
+		Mode:                  ModePersistOnly,
+		Directory:             persistDir,
+		EncryptionKeyProvider: provider,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, c.ReportEvent(context.Background(), userLoginEvent))
+	c.Close(context.Background())
+
+	var lines [][]byte
+	err = filepath.WalkDir(persistDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == "funnydb.lock" {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, bytes.Split(bytes.TrimSpace(b), []byte("\n"))...)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lines)
+
+	// 包括 client 关闭时写入的统计数据，每一行都是加密的
+	var decoded []string
+	for _, line := range lines {
+		assert.NotEqual(t, byte('{'), line[0])
+		data, err := internal.DecodeLogLine(internal.NewRecordCipher(provider), line)
+		assert.Nil(t, err)
+		decoded = append(decoded, string(data))
+	}
+	assert.Contains(t, decoded[0], "account-fake955582")
+
+	config := &Config{
+		Mode:                  ModeAsync,
+		IngestEndpoint:        "http://ingest.com",
+		SendTimeout:           5 * time.Second,
+		AccessKey:             "demo",
+		AccessSecret:          "demo",
+		Directory:             filepath.Join(tmpDir, "async"),
+		EncryptionKeyProvider: provider,
+	}
+	c, err = NewClient(config)
+	assert.Nil(t, err)
+
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Times(1).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	assert.Nil(t, c.ReportEvent(context.Background(), userLoginEvent))
+
+	waitingForResponse()
+
+	c.Close(context.Background())
+}
+
+// 测试超过 MaxRecordAge 的数据在发送时丢弃
+func TestAsyncClientMaxRecordAge(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	discarded := make(chan DiscardedRecord, 10)
+	config := &Config{
+		Mode:           ModeAsync,
+		IngestEndpoint: "http://ingest.com",
+		SendTimeout:    5 * time.Second,
+		AccessKey:      "demo",
+		AccessSecret:   "demo",
+		Directory:      tmpDir,
+		MaxRecordAge:   time.Hour,
+		OnDiscard: func(record DiscardedRecord) {
+			discarded <- record
+		},
+	}
+	c, err := NewClient(config)
+	assert.Nil(t, err)
+
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Times(1).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	expiredTime := time.Now().Add(-2 * time.Hour)
+	events := []*Event{
+		{Name: "UserLogin", Time: expiredTime, Props: map[string]interface{}{"#account_id": "account-1"}},
+		{Name: "UserLogout", Props: map[string]interface{}{"#account_id": "account-1"}},
+	}
+	assert.Nil(t, c.ReportEvents(context.Background(), events))
+
+	waitingForResponse()
+
+	record := <-discarded
+	assert.Equal(t, DiscardReasonExpired, record.Reason)
+	assert.Equal(t, "UserLogin", record.Name)
+	assert.Equal(t, expiredTime.UnixMilli(), record.Time.UnixMilli())
+	assert.Contains(t, string(record.Data), "account-1")
+
+	c.stat.mu.Lock()
+	assert.Equal(t, int64(1), c.stat.stats[statKey{
+		reportEvent:   "UserLogin",
+		beginTime:     expiredTime.Round(c.stat.reportInterval),
+		discardReason: DiscardReasonExpired,
+	}])
+	c.stat.mu.Unlock()
+
+	event := c.stat.makeEvent(statKey{reportEvent: "UserLogin", discardReason: DiscardReasonExpired}, 1)
+	assert.Equal(t, int64(1), event.Props["discard_total"])
+	assert.NotContains(t, event.Props, "report_total")
+
+	c.Close(context.Background())
+}
+
+// 测试优先级通道使用独立的磁盘队列
+func TestAsyncClientPriorityLanes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Mode:           ModeAsync,
+		IngestEndpoint: "http://ingest.com",
+		SendTimeout:    5 * time.Second,
+		AccessKey:      "demo",
+		AccessSecret:   "demo",
+		Directory:      tmpDir,
+		PriorityLanes:  true,
+	}
+	c, err := NewClient(config)
+	assert.Nil(t, err)
+
+	// 不同通道的数据分批发送
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Times(3).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	events := []*Event{
+		{Name: "PaymentSucceeded", Priority: PriorityHigh, Props: map[string]interface{}{"#account_id": "account-1"}},
+		{Name: "UserLogin", Props: map[string]interface{}{"#account_id": "account-1"}},
+	}
+	assert.Nil(t, c.ReportEvents(context.Background(), events))
+	assert.Nil(t, c.ReportEvent(context.Background(), &Event{Name: "Debug", Priority: PriorityLow, Props: map[string]interface{}{}}))
+
+	waitingForResponse()
+
+	c.Close(context.Background())
+
+	for _, name := range []string{"funnydb", "funnydb-high", "funnydb-low"} {
+		_, err := os.Stat(filepath.Join(tmpDir, name+".diskqueue.meta.dat"))
+		assert.Nil(t, err, name)
+	}
+}
+
+// 测试暂停与恢复发送
+func TestAsyncClientPauseSending(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	c, err := createClient(tmpDir)
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.PauseSending())
+	assert.Nil(t, c.PauseSending())
+
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Times(1).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	err = c.ReportEvent(context.Background(), userLoginEvent)
+	assert.Nil(t, err)
+
+	// 暂停期间数据只写入磁盘队列
+	time.Sleep(5 * c.config.SendInterval)
+	assert.False(t, gock.IsDone())
+	status, err := c.SendingStatus()
+	assert.Nil(t, err)
+	assert.True(t, status.Paused)
+	assert.False(t, status.PausedAt.IsZero())
+	assert.Equal(t, int64(1), status.Pending)
+
+	assert.Nil(t, c.ResumeSending())
+	waitingForResponse()
+
+	assert.Eventually(t, func() bool {
+		status, err := c.SendingStatus()
+		return err == nil && !status.Paused && status.Pending == 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// 暂停期间可以正常关闭
+	assert.Nil(t, c.PauseSending())
+	assert.Nil(t, c.Close(context.Background()))
+
+	c, err = NewClient(&Config{Mode: ModePersistOnly, Directory: tmpDir})
+	assert.Nil(t, err)
+	assert.ErrorIs(t, c.PauseSending(), ErrSendingPauseUnsupported)
+	_, err = c.SendingStatus()
+	assert.ErrorIs(t, err, ErrSendingPauseUnsupported)
+	c.Close(context.Background())
+}
+
+// 测试发送限速
+func TestAsyncClientRateLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	c, err := NewClient(&Config{
+		Mode:             ModeAsync,
+		IngestEndpoint:   "http://ingest.com",
+		SendTimeout:      5 * time.Second,
+		AccessKey:        "demo",
+		AccessSecret:     "demo",
+		Directory:        tmpDir,
+		MaxBufferRecords: 1,
+		RateLimit:        RateLimit{RequestsPerSecond: 2},
+	})
+	assert.Nil(t, err)
+
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Times(3).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		err = c.ReportEvent(context.Background(), &Event{Name: "UserLogin", Props: map[string]interface{}{}})
+		assert.Nil(t, err)
+	}
+	waitingForResponse()
+
+	// 前两个请求不受限制，第三个请求等待 0.5s
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	status, err := c.ThrottleStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), status.ThrottledTimes)
+	assert.Equal(t, 2.0, status.Limit.RequestsPerSecond)
+
+	assert.ErrorIs(t, c.SetRateLimit(RateLimit{BytesPerSecond: -1}), ErrConfigRateLimitIllegal)
+	assert.Nil(t, c.SetRateLimit(RateLimit{}))
+	status, err = c.ThrottleStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimit{}, status.Limit)
+	assert.Nil(t, c.Close(context.Background()))
+
+	c, err = NewClient(&Config{Mode: ModePersistOnly, Directory: tmpDir})
+	assert.Nil(t, err)
+	assert.ErrorIs(t, c.SetRateLimit(RateLimit{}), ErrRateLimitUnsupported)
+	c.Close(context.Background())
+}
+
+// 测试 simple 模式被限速时 Close 不会阻塞，剩余数据直接发送
+func TestSimpleClientRateLimitClose(t *testing.T) {
+	defer gock.Off()
+
+	c, err := NewClient(&Config{
+		Mode:               ModeSimple,
+		IngestEndpoint:     "http://ingest.com",
+		AccessKey:          "demo",
+		AccessSecret:       "demo",
+		MaxBufferRecords:   1,
+		RateLimit:          RateLimit{BytesPerSecond: 10},
+		DisableReportStats: true,
+	})
+	assert.Nil(t, err)
+
+	createGockReq().
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	// 数据约 200 字节，需要等待约 20s
+	err = c.ReportEvent(context.Background(), &Event{Name: "UserLogin", Props: map[string]interface{}{}})
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	status, err := c.ThrottleStatus()
+	assert.Nil(t, err)
+	assert.True(t, status.Throttling)
+
+	start := time.Now()
+	assert.Nil(t, c.Close(context.Background()))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.True(t, gock.IsDone())
+}
+
+// 测试 ingest 请求体使用 zstd 压缩
+func TestSimpleClientRequestCompression(t *testing.T) {
+	defer gock.Off()
+
+	c, err := NewClient(&Config{
+		Mode:               ModeSimple,
+		IngestEndpoint:     "http://ingest.com",
+		AccessKey:          "demo",
+		AccessSecret:       "demo",
+		RequestCompression: "zstd",
+	})
+	assert.Nil(t, err)
+
+	matcher := gock.NewBasicMatcher()
+	matcher.Add(func(req *http.Request, ereq *gock.Request) (bool, error) {
+		if req.Header.Get("Content-Encoding") != "zstd" {
+			return false, nil
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return false, err
+		}
+		d, err := zstd.NewReader(nil)
+		if err != nil {
+			return false, err
+		}
+		defer d.Close()
+		data, err := d.DecodeAll(body, nil)
+		if err != nil {
+			return false, err
+		}
+		var batch client.Messages
+		if err := jsoniter.Unmarshal(data, &batch); err != nil {
+			return false, err
+		}
+		return len(batch.Messages) == 1, nil
+	})
+	createGockReq().
+		SetMatcher(matcher).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	err = c.ReportEvent(context.Background(), &Event{Name: "UserLogin", Props: map[string]interface{}{}})
+	assert.Nil(t, err)
+	waitingForResponse()
+	c.Close(context.Background())
+
+	_, err = NewClient(&Config{Mode: ModeSimple, IngestEndpoint: "http://ingest.com", RequestCompression: "br"})
+	assert.ErrorIs(t, err, ErrConfigRequestCompressionIllegal)
+}
+
+// 测试单条数据超过 BatchSize 时写入 dead-letter 文件
+func TestAsyncClientOversizedRecord(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	var mu sync.Mutex
+	var discarded []DiscardedRecord
+	c, err := NewClient(&Config{
+		Mode:           ModeAsync,
+		IngestEndpoint: "http://ingest.com",
+		SendTimeout:    5 * time.Second,
+		AccessKey:      "demo",
+		AccessSecret:   "demo",
+		Directory:      tmpDir,
+		BatchSize:      4096,
+		OnDiscard: func(record DiscardedRecord) {
+			mu.Lock()
+			defer mu.Unlock()
+			discarded = append(discarded, record)
+		},
+	})
+	assert.Nil(t, err)
+
+	createGockReq().
+		SetMatcher(singleMessageMatcher).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	large := &Event{Name: "LargeEvent", Props: map[string]interface{}{"payload": strings.Repeat("x", 8192)}}
+	err = c.ReportEvents(context.Background(), []*Event{large, {Name: "UserLogin", Props: map[string]interface{}{}}})
+	assert.Nil(t, err)
+	waitingForResponse()
+
+	assert.Eventually(t, func() bool {
+		status, err := c.SendingStatus()
+		return err == nil && status.Pending == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	c.Close(context.Background())
+
+	mu.Lock()
+	assert.Len(t, discarded, 1)
+	assert.Equal(t, DiscardReasonTooLarge, discarded[0].Reason)
+	assert.Equal(t, "LargeEvent", discarded[0].Name)
+	mu.Unlock()
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, DeadLetterFileName))
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 1)
+	assert.Equal(t, "LargeEvent", jsoniter.Get([]byte(lines[0]), "data", "#event").ToString())
+}
+
+// 测试多个 ingest 地址之间自动切换
+func TestAsyncClientEndpointFailover(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	c, err := NewClient(&Config{
+		Mode:            ModeAsync,
+		IngestEndpoints: []string{"http://ingest-a.com", "http://ingest-b.com"},
+		SendTimeout:     5 * time.Second,
+		AccessKey:       "demo",
+		AccessSecret:    "demo",
+		Directory:       tmpDir,
+	})
+	assert.Nil(t, err)
+
+	gock.New("http://ingest-a.com").Post("/v1/collect").Reply(502).BodyString("bad gateway")
+	gock.New("http://ingest-b.com").Post("/v1/collect").
+		SetMatcher(singleMessageMatcher).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	err = c.ReportEvent(context.Background(), &Event{Name: "UserLogin", Props: map[string]interface{}{}})
+	assert.Nil(t, err)
+	waitingForResponse()
+	c.Close(context.Background())
+
+	_, err = NewClient(&Config{Mode: ModeSimple, IngestEndpoints: []string{"http://ingest-a.com"}, EndpointStrategy: "random"})
+	assert.ErrorIs(t, err, ErrConfigEndpointStrategyIllegal)
+}
+
+// 测试路由到使用独立访问地址与 key 的目标
+func TestAsyncClientRouting(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	c, err := NewClient(&Config{
+		Mode:           ModeAsync,
+		IngestEndpoint: "http://ingest.com",
+		SendTimeout:    5 * time.Second,
+		AccessKey:      "demo",
+		AccessSecret:   "demo",
+		Directory:      tmpDir,
+		Destinations: []Destination{
+			{Name: "payment", IngestEndpoint: "http://ingest-b.com", AccessKey: "payment", AccessSecret: "secret"},
+		},
+		Routes: []RouteRule{{Events: []string{"PayOrder"}, Destination: "payment"}},
+	})
+	assert.Nil(t, err)
+
+	createGockReq().
+		MatchHeader("X-AccessKeyId", "^demo$").
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+	gock.New("http://ingest-b.com").Post("/v1/collect").
+		MatchHeader("X-AccessKeyId", "^payment$").
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	err = c.ReportEvents(context.Background(), []*Event{
+		{Name: "UserLogin", Props: map[string]interface{}{}},
+		{Name: "PayOrder", Props: map[string]interface{}{}},
+	})
+	assert.Nil(t, err)
+	waitingForResponse()
+
+	assert.Eventually(t, func() bool {
+		status, err := c.SendingStatus()
+		return err == nil && status.Pending == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Nil(t, c.Close(context.Background()))
+	assert.FileExists(t, filepath.Join(tmpDir, "destinations", "payment", "funnydb.diskqueue.meta.dat"))
+}
+
 // 测试正常重启后数据不会重复发送
 func TestAsyncClientNormalRestart(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-async-test-%d", time.Now().UnixNano()))
@@ -237,3 +797,36 @@ func TestAsyncClientServerErrorRestart(t *testing.T) {
 
 	c.Close(context.Background())
 }
+
+// 测试同一文件夹不能被多个 client 同时使用
+func TestClientDirectoryLocked(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("client-lock-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	newConfig := func() *Config {
+		return &Config{
+			Mode:      ModePersistOnly,
+			Directory: tmpDir,
+		}
+	}
+
+	c, err := NewClient(newConfig())
+	assert.Nil(t, err)
+
+	_, err = NewClient(newConfig())
+	assert.ErrorIs(t, err, ErrDirectoryLocked)
+
+	// 在等待超时前释放锁，新的 client 可以正常创建
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		c.Close(context.Background())
+	}()
+
+	config := newConfig()
+	config.DirectoryLockTimeout = 5 * time.Second
+	c, err = NewClient(config)
+	assert.Nil(t, err)
+
+	c.Close(context.Background())
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/funny/funnydb-go-sdk/v2/internal/diskqueue"
 )
 
 type Mode string
@@ -25,13 +26,52 @@ const (
 	DefaultSendTimeout      = 30 * time.Second
 	DefaultLogFileSize      = 128
 	DefaultBatchSize        = 10 * 1024 * 1024 // 10MB
+
+	EndpointStrategyFailover   = internal.EndpointStrategyFailover   // 优先使用靠前的地址，失败时切换到下一个，恢复后切换回来
+	EndpointStrategyRoundRobin = internal.EndpointStrategyRoundRobin // 轮流使用健康的地址
+	EndpointStrategyLatency    = internal.EndpointStrategyLatency    // 使用平均延迟最低的健康地址
 )
 
 var ErrUnknownProducerType = errors.New("unknown producer type")
 var ErrConfigIngestEndpointIllegal = errors.New("producer config IngestEndpoint can not be empty")
+var ErrConfigEndpointStrategyIllegal = errors.New("producer config EndpointStrategy must be one of failover, round_robin, latency")
 var ErrConfigAccessKeyIllegal = errors.New("producer config AccessKey can not be empty")
 var ErrConfigAccessSecretIllegal = errors.New("producer config AccessSecret can not be empty")
 var ErrConfigDirectoryIllegal = errors.New("producer config Directory can not be empty")
+var ErrConfigQueueCompressionIllegal = errors.New("producer config QueueCompression must be one of none, gzip, zstd, snappy")
+var ErrConfigRequestCompressionIllegal = errors.New("producer config RequestCompression must be one of none, gzip, zstd")
+var ErrConfigRateLimitIllegal = errors.New("producer config RateLimit can not be negative")
+var ErrDirectoryLocked = internal.ErrDirectoryLocked
+
+// DiscardedRecord 未发送而被丢弃的数据，见 Config.OnDiscard
+type DiscardedRecord = internal.DiscardedRecord
+
+const (
+	DiscardReasonExpired  = internal.DiscardReasonExpired  // 超过 Config.MaxRecordAge
+	DiscardReasonTooLarge = internal.DiscardReasonTooLarge // 单条数据就超过请求大小限制，async 模式下同时写入 DeadLetterFileName
+)
+
+// DeadLetterFileName async 模式下无法发送的数据保存在 Directory 下的该文件中，格式与 persist_only 模式的日志相同
+const DeadLetterFileName = internal.DeadLetterFileName
+
+// HTTPConfig 连接 ingest 使用的 HTTP 设置，见 Config.HTTP
+type HTTPConfig = internal.HTTPConfig
+
+// Priority 数据发送的优先级通道，见 Config.PriorityLanes
+type Priority = internal.Lane
+
+// RateLimit 向 ingest 发送的限速，见 Config.RateLimit
+type RateLimit = internal.RateLimit
+
+// ThrottleStatus 限速状态，见 Client.ThrottleStatus
+type ThrottleStatus = internal.ThrottleStatus
+
+const (
+	PriorityDefault = internal.LaneDefault // Mutation 为 PriorityHigh，SDK 统计数据为 PriorityLow，其余事件为 PriorityNormal
+	PriorityHigh    = internal.LaneHigh
+	PriorityNormal  = internal.LaneNormal
+	PriorityLow     = internal.LaneLow
+)
 
 type Config struct {
 	Mode Mode
@@ -43,10 +83,51 @@ type Config struct {
 	SendInterval     time.Duration // 当缓存数量达不到 MaxBufferSize，间隔一段时间也会发送数据到 ingest
 	SendTimeout      time.Duration // 发送 ingest 请求超时时间
 
-	Directory string // 日志存储文件夹（不同项目之间请不要使用同一文件夹）
-	FileSize  int64  // 单个日志文件最大大小 (MB)
+	IngestEndpoints       []string      // 更多访问地址（例如其他地域的 ingest），与 IngestEndpoint 一起按 EndpointStrategy 选择，不可用时自动切换
+	EndpointStrategy      string        // 多个访问地址的选择策略: failover, round_robin, latency，默认 failover
+	EndpointProbeInterval time.Duration // 主动探测各访问地址是否可用的间隔，默认只根据发送结果判断
+
+	HTTP HTTPConfig // 自定义 http.Client、代理、CA 证书、客户端证书以及连接池设置
+
+	Directory            string        // 日志存储文件夹（不同项目之间请不要使用同一文件夹，已被其他进程占用时创建 client 返回 ErrDirectoryLocked）
+	FileSize             int64         // 单个日志文件最大大小 (MB)
+	DirectoryLockTimeout time.Duration // 存储文件夹被其他进程占用时，等待其释放的最长时间，默认不等待
+
+	BatchSize int64 // 单个 ingest 请求体（压缩前）的最大字节数，缓存数据达到该值时立刻发送；ingest 返回请求过大时自动拆分重发
+
+	QueueChecksum    bool   // 异步模式磁盘队列的每条记录附带 CRC32C 校验（开启后旧版本 SDK 无法读取新写入的队列文件）
+	QueueCompression string // 异步模式磁盘队列记录的压缩算法: gzip, zstd, snappy，默认不压缩（开启后同样附带校验）
+
+	EncryptionKeyProvider KeyProvider // 设置后 persist_only 与 async 模式落盘的数据使用 AES-GCM 加密，未加密的旧数据仍可读取
+
+	MaxRecordAge time.Duration         // 异步模式下 #time 早于该时长的数据在发送时丢弃，默认不丢弃
+	OnDiscard    func(DiscardedRecord) // 数据被丢弃时调用，丢弃数量同时计入 #sdk_send_stats
+
+	PriorityLanes bool             // simple 与 async 模式下按优先级使用独立的缓冲区（async 模式为独立的磁盘队列）发送数据
+	LaneWeights   map[Priority]int // 各通道都有积压时每一轮发送的批次数，默认 high:6 normal:3 low:1
+
+	RequestCompression     string // 发送到 ingest 的请求体压缩算法: none, gzip, zstd，默认 gzip（zstd 为实验性选项，需要 ingest 服务端支持，确认支持前不要开启）
+	RequestCompressMinSize int    // 请求体小于该字节数时不压缩，默认总是压缩
+
+	RateLimit RateLimit // simple 与 async 模式下向 ingest 发送的字节数与请求数限速，默认不限制，运行时可通过 Client.SetRateLimit 调整
+
+	SuperProperties        map[string]interface{}     // 公共属性，添加到每个事件，运行时可通过 Client.SetSuperProperties 等方法修改
+	DynamicSuperProperties DynamicSuperPropertiesFunc // 上报每个事件时调用，返回的属性优先于 SuperProperties，Event.Props 中同名的属性优先于两者
+
+	ContextExtractors []ContextExtractor // 从 ReportEvent 的 ctx 中提取事件属性，WithProps 设置的属性总是会添加，且优先于提取的属性
+
+	PropsValidation string // Event 与 Mutation 属性值的校验方式: strict, lenient，默认 strict，见 normalizePropValue
+
+	ReservedFieldCheck    string   // 属性中由 SDK 填充或未知的 # 开头字段的处理方式: warn, reject, none，默认 warn
+	AllowedReservedFields []string // 预置字段之外允许在属性中设置的 # 开头字段
+
+	Schema     *Schema // 校验 Event 与 Mutation 属性的 Schema，可通过 LoadSchema 从文件读取，运行时可通过 Client.SetSchema 替换
+	SchemaMode string  // 数据不符合 Schema 时的处理方式: reject, warn, tag（只对事件生效，Mutation 按 warn 处理），默认 reject
+
+	Middlewares []Middleware // 上报的每条 Event 与 Mutation 在校验前依次经过的中间件，运行时可通过 Client.Use 添加
 
-	BatchSize int64 // 当缓存数据字节数超过该值，立刻发送这批数据到 ingest
+	Destinations []Destination // 路由目标，每个目标使用独立的访问 key 与缓冲区，与 Config 本身的目标共用同一个 Client 与发送调度
+	Routes       []RouteRule   // 按顺序匹配的路由规则，都不满足的数据发送到 Config 本身的目标
 
 	DisableReportStats bool // 是否关闭发送统计数据到 ingest
 
@@ -70,6 +151,23 @@ func (c *Config) checkConfig() error {
 	if err != nil {
 		return err
 	}
+	if err := c.checkRoutes(); err != nil {
+		return err
+	}
+	if err := checkPropsValidation(c.PropsValidation); err != nil {
+		return err
+	}
+	if err := checkReservedFieldCheck(c.ReservedFieldCheck); err != nil {
+		return err
+	}
+	if err := checkSchemaMode(c.SchemaMode); err != nil {
+		return err
+	}
+	if c.Schema != nil {
+		if err := c.Schema.check(); err != nil {
+			return err
+		}
+	}
 
 	if c.Hostname == "" {
 		hostname, err := os.Hostname()
@@ -84,9 +182,14 @@ func (c *Config) checkConfig() error {
 }
 
 func (c *Config) checkIngestProducerConfigAndSetDefaultValue() error {
-	if c.IngestEndpoint == "" {
+	if c.IngestEndpoint == "" && len(c.IngestEndpoints) == 0 {
 		return ErrConfigIngestEndpointIllegal
 	}
+	switch c.EndpointStrategy {
+	case "", EndpointStrategyFailover, EndpointStrategyRoundRobin, EndpointStrategyLatency:
+	default:
+		return ErrConfigEndpointStrategyIllegal
+	}
 	if c.MaxBufferRecords == 0 {
 		c.MaxBufferRecords = DefaultMaxBufferRecords
 	}
@@ -96,6 +199,17 @@ func (c *Config) checkIngestProducerConfigAndSetDefaultValue() error {
 	if c.SendTimeout == 0 {
 		c.SendTimeout = DefaultSendTimeout
 	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	switch c.RequestCompression {
+	case "", internal.RequestCompressionNone, internal.RequestCompressionGzip, internal.RequestCompressionZstd:
+	default:
+		return ErrConfigRequestCompressionIllegal
+	}
+	if c.RateLimit.BytesPerSecond < 0 || c.RateLimit.RequestsPerSecond < 0 {
+		return ErrConfigRateLimitIllegal
+	}
 	return nil
 }
 
@@ -116,8 +230,8 @@ func (c *Config) checkAsyncProducerConfigAndSetDefaultValue() error {
 	if c.Directory == "" {
 		return ErrConfigDirectoryIllegal
 	}
-	if c.BatchSize == 0 {
-		c.BatchSize = DefaultBatchSize
+	if _, err := diskqueue.ParseCompression(c.QueueCompression); err != nil {
+		return ErrConfigQueueCompressionIllegal
 	}
 	return nil
 }
@@ -131,13 +245,27 @@ func (c *Config) generateIngestProducerConfig() *internal.IngestProducerConfig {
 		MaxBufferRecords: c.MaxBufferRecords,
 		SendInterval:     c.SendInterval,
 		SendTimeout:      c.SendTimeout,
+		BatchSize:        c.BatchSize,
+		PriorityLanes:    c.PriorityLanes,
+		RateLimit:        c.RateLimit,
+
+		RequestCompression:     c.RequestCompression,
+		RequestCompressMinSize: c.RequestCompressMinSize,
+
+		IngestEndpoints:       c.IngestEndpoints,
+		EndpointStrategy:      c.EndpointStrategy,
+		EndpointProbeInterval: c.EndpointProbeInterval,
+
+		HTTP: c.HTTP,
 	}
 }
 
 func (c *Config) generateLogProducerConfig() *internal.LogProducerConfig {
 	return &internal.LogProducerConfig{
-		Directory: c.Directory,
-		FileSize:  c.FileSize,
+		Directory:   c.Directory,
+		FileSize:    c.FileSize,
+		LockTimeout: c.DirectoryLockTimeout,
+		KeyProvider: c.EncryptionKeyProvider,
 	}
 }
 
@@ -152,5 +280,22 @@ func (c *Config) generateAsyncProducerConfig() *internal.AsyncProducerConfig {
 		SendInterval:     c.SendInterval,
 		SendTimeout:      c.SendTimeout,
 		BatchSize:        c.BatchSize,
+		LockTimeout:      c.DirectoryLockTimeout,
+		QueueChecksum:    c.QueueChecksum,
+		QueueCompression: c.QueueCompression,
+		KeyProvider:      c.EncryptionKeyProvider,
+		MaxRecordAge:     c.MaxRecordAge,
+		PriorityLanes:    c.PriorityLanes,
+		LaneWeights:      c.LaneWeights,
+		RateLimit:        c.RateLimit,
+
+		RequestCompression:     c.RequestCompression,
+		RequestCompressMinSize: c.RequestCompressMinSize,
+
+		IngestEndpoints:       c.IngestEndpoints,
+		EndpointStrategy:      c.EndpointStrategy,
+		EndpointProbeInterval: c.EndpointProbeInterval,
+
+		HTTP: c.HTTP,
 	}
 }
@@ -0,0 +1,58 @@
+package funnydb
+
+import "context"
+
+type propsContextKey struct{}
+
+// WithProps 返回附带事件属性的 ctx，通过该 ctx 上报的事件会添加这些属性，
+// 适合在请求入口设置 trace id、#account_id、#ip 等。多次调用时属性合并，后设置的优先
+func WithProps(ctx context.Context, props map[string]interface{}) context.Context {
+	parent := PropsFromContext(ctx)
+	merged := make(map[string]interface{}, len(parent)+len(props))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range props {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, propsContextKey{}, merged)
+}
+
+// PropsFromContext 返回通过 WithProps 设置的属性，不要修改返回的 map
+func PropsFromContext(ctx context.Context) map[string]interface{} {
+	props, _ := ctx.Value(propsContextKey{}).(map[string]interface{})
+	return props
+}
+
+// ContextExtractor 从上报事件的 ctx 中提取事件属性，例如读取 HTTP 中间件保存在 ctx 中的请求信息
+type ContextExtractor interface {
+	Extract(ctx context.Context) map[string]interface{}
+}
+
+// ContextExtractorFunc 将函数转换为 ContextExtractor
+type ContextExtractorFunc func(ctx context.Context) map[string]interface{}
+
+func (f ContextExtractorFunc) Extract(ctx context.Context) map[string]interface{} {
+	return f(ctx)
+}
+
+// contextProps 返回 ctx 中的事件属性，WithProps 设置的属性优先于 Config.ContextExtractors 提取的属性
+func (c *Client) contextProps(ctx context.Context) map[string]interface{} {
+	var merged map[string]interface{}
+	add := func(props map[string]interface{}) {
+		if len(props) == 0 {
+			return
+		}
+		if merged == nil {
+			merged = make(map[string]interface{}, len(props))
+		}
+		for k, v := range props {
+			merged[k] = v
+		}
+	}
+	for _, extractor := range c.config.ContextExtractors {
+		add(extractor.Extract(ctx))
+	}
+	add(PropsFromContext(ctx))
+	return merged
+}
@@ -0,0 +1,56 @@
+package funnydb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type traceIDKey struct{}
+
+func TestWithProps(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, PropsFromContext(ctx))
+
+	props := map[string]interface{}{"#account_id": "a1", "request_id": "r1"}
+	ctx1 := WithProps(ctx, props)
+	ctx2 := WithProps(ctx1, map[string]interface{}{"request_id": "r2", "#ip": "127.0.0.1"})
+	props["#account_id"] = "changed"
+
+	assert.Equal(t, map[string]interface{}{"#account_id": "a1", "request_id": "r1"}, PropsFromContext(ctx1))
+	assert.Equal(t, map[string]interface{}{"#account_id": "a1", "request_id": "r2", "#ip": "127.0.0.1"}, PropsFromContext(ctx2))
+}
+
+func TestClientContextProps(t *testing.T) {
+	c, err := NewClient(&Config{
+		Mode:            ModeNoop,
+		SuperProperties: map[string]interface{}{"region": "cn", "request_id": "super"},
+		ContextExtractors: []ContextExtractor{
+			ContextExtractorFunc(func(ctx context.Context) map[string]interface{} {
+				if traceID, ok := ctx.Value(traceIDKey{}).(string); ok {
+					return map[string]interface{}{"trace_id": traceID, "#ip": "10.0.0.1"}
+				}
+				return nil
+			}),
+		},
+	})
+	assert.Nil(t, err)
+	defer c.Close(context.Background())
+
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "t1")
+	ctx = WithProps(ctx, map[string]interface{}{"request_id": "r1", "#ip": "127.0.0.1"})
+
+	e := &Event{Name: "UserLogin", Props: map[string]interface{}{"region": "us"}}
+	data, err := e.transformToReportableData("localhost", c.superPropsFor(ctx, e))
+	assert.Nil(t, err)
+	dataMap := data["data"].(map[string]interface{})
+	assert.Equal(t, "us", dataMap["region"])
+	assert.Equal(t, "r1", dataMap["request_id"])
+	assert.Equal(t, "t1", dataMap["trace_id"])
+	// WithProps 优先于 ContextExtractors
+	assert.Equal(t, "127.0.0.1", dataMap["#ip"])
+
+	// ctx 没有属性时只添加公共属性
+	assert.Equal(t, map[string]interface{}{"region": "cn", "request_id": "super"}, c.superPropsFor(context.Background(), e))
+}
@@ -0,0 +1,32 @@
+package funnydb
+
+import "github.com/funny/funnydb-go-sdk/v2/internal"
+
+// KeyProvider 提供落盘数据加密使用的 AES 密钥（16、24 或 32 字节），见 Config.EncryptionKeyProvider
+//
+// 数据使用 CurrentKey 加密并记录密钥 ID，读取时通过 Key 查找对应的密钥，
+// 轮换密钥后需要保留旧密钥，直到旧数据都已发送（async）或导出（persist_only）
+type KeyProvider = internal.KeyProvider
+
+type StaticKeyProvider = internal.StaticKeyProvider
+type KeyFileProvider = internal.KeyFileProvider
+type CallbackKeyProvider = internal.CallbackKeyProvider
+
+var ErrUnknownEncryptionKey = internal.ErrUnknownEncryptionKey
+var ErrKeyCallbackNil = internal.ErrKeyCallbackNil
+
+// NewStaticKeyProvider 使用固定的密钥，调用 Rotate 轮换
+func NewStaticKeyProvider(id string, key []byte) *StaticKeyProvider {
+	return internal.NewStaticKeyProvider(id, key)
+}
+
+// NewKeyFileProvider 从密钥文件读取密钥，文件每行为 "<id> <base64 编码的密钥>"，
+// 第一个密钥用于加密。文件修改后会自动重新加载，utilities/extract 可使用同一文件解密
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	return internal.NewKeyFileProvider(path)
+}
+
+// NewCallbackKeyProvider 通过回调获取密钥，例如从 KMS 获取，任一回调为 nil 时返回 ErrKeyCallbackNil
+func NewCallbackKeyProvider(current func() (id string, key []byte, err error), lookup func(id string) ([]byte, error)) (*CallbackKeyProvider, error) {
+	return internal.NewCallbackKeyProvider(current, lookup)
+}
@@ -10,33 +10,47 @@ import (
 var ErrEventDataNameIllegal = errors.New("event data name can not be empty")
 
 type Event struct {
-	Name  string
-	Time  time.Time
-	Props map[string]interface{}
+	Name     string
+	Time     time.Time
+	Props    map[string]interface{}
+	Priority Priority // 开启 Config.PriorityLanes 后使用的通道，默认为 PriorityNormal
+
+	RoutingKey string // 按 Config.Routes 选择发送的目标，不会上报
 }
 
-func (e *Event) transformToReportableData(hostname string) (map[string]interface{}, error) {
-	e.Props[internal.DataFieldNameSdkType] = internal.SdkType
-	e.Props[internal.DataFieldNameSdkVersion] = internal.SdkVersion
-	e.Props[internal.DataFieldNameHostname] = hostname
-	e.Props[internal.DataFieldNameEvent] = e.Name
+// transformToReportableData 转换为上报的数据，superProps 为公共属性，与 Props 中同名的属性以 Props 为准。
+// 上报的属性写入新的 map，不会修改 Props 以及 Event 本身，同一个 Event 可以在多个 goroutine 之间共用
+func (e *Event) transformToReportableData(hostname string, superProps map[string]interface{}) (map[string]interface{}, error) {
+	props := make(map[string]interface{}, len(superProps)+len(e.Props)+6)
+	for k, v := range superProps {
+		props[k] = v
+	}
+	for k, v := range e.Props {
+		props[k] = v
+	}
+
+	props[internal.DataFieldNameSdkType] = internal.SdkType
+	props[internal.DataFieldNameSdkVersion] = internal.SdkVersion
+	props[internal.DataFieldNameHostname] = hostname
+	props[internal.DataFieldNameEvent] = e.Name
 
-	if e.Time.IsZero() {
-		e.Time = time.Now()
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
 	}
-	e.Props[internal.DataFieldNameTime] = e.Time.UnixMilli()
+	props[internal.DataFieldNameTime] = t.UnixMilli()
 
-	if _, ok := e.Props[internal.DataFieldNameLogId]; !ok {
+	if _, ok := props[internal.DataFieldNameLogId]; !ok {
 		logId, err := internal.GenerateLogId()
 		if err != nil {
 			return nil, err
 		}
-		e.Props[internal.DataFieldNameLogId] = logId
+		props[internal.DataFieldNameLogId] = logId
 	}
 
 	return map[string]interface{}{
 		"type": internal.EventTypeValue,
-		"data": e.Props,
+		"data": props,
 	}, nil
 }
 
@@ -1,6 +1,8 @@
 package funnydb
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,7 +23,7 @@ func TestEvent_transformToReportableData(t *testing.T) {
 		Time:  eventTime,
 		Props: eventProps,
 	}
-	reportableData, err := e.transformToReportableData("localhost")
+	reportableData, err := e.transformToReportableData("localhost", nil)
 	assert.Nil(t, err)
 
 	assert.Equal(t, internal.EventTypeValue, reportableData["type"].(string))
@@ -39,9 +41,75 @@ func TestEvent_transformToReportableData(t *testing.T) {
 		Name:  eventName,
 		Props: eventProps,
 	}
-	reportableData2, err := e2.transformToReportableData("localhost")
+	reportableData2, err := e2.transformToReportableData("localhost", nil)
 	assert.Nil(t, err)
 
 	dataMap2 := reportableData2["data"].(map[string]interface{})
 	assert.Equal(t, true, dataMap2[internal.DataFieldNameTime].(int64) >= eventTime.UnixMilli())
+	// 不修改传入的 Event
+	assert.True(t, e2.Time.IsZero())
+}
+
+func TestEvent_transformToReportableDataSharedProps(t *testing.T) {
+	props := map[string]interface{}{"field1": 1}
+
+	e1 := &Event{Name: "UserLogin", Props: props}
+	data1, err := e1.transformToReportableData("localhost", nil)
+	assert.Nil(t, err)
+	e2 := &Event{Name: "UserLogout", Props: props}
+	data2, err := e2.transformToReportableData("localhost", nil)
+	assert.Nil(t, err)
+
+	// 不修改传入的 Props，每个事件有各自的 #log_id
+	assert.Equal(t, map[string]interface{}{"field1": 1}, props)
+	dataMap1 := data1["data"].(map[string]interface{})
+	dataMap2 := data2["data"].(map[string]interface{})
+	assert.NotEqual(t, dataMap1[internal.DataFieldNameLogId], dataMap2[internal.DataFieldNameLogId])
+	assert.Equal(t, "UserLogin", dataMap1[internal.DataFieldNameEvent])
+	assert.Equal(t, "UserLogout", dataMap2[internal.DataFieldNameEvent])
+
+	// 指定的 #log_id 仍然保留
+	e3 := &Event{Name: "UserLogin", Props: map[string]interface{}{internal.DataFieldNameLogId: "log-1"}}
+	data3, err := e3.transformToReportableData("localhost", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "log-1", data3["data"].(map[string]interface{})[internal.DataFieldNameLogId])
+
+	// Props 为 nil 时视为空
+	e4 := &Event{Name: "UserLogin"}
+	data4, err := e4.transformToReportableData("localhost", map[string]interface{}{"channel": "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, "a", data4["data"].(map[string]interface{})["channel"])
+}
+
+func TestClientReportEventSharedPropsConcurrent(t *testing.T) {
+	c, p := newRecordingClient(t, &Config{SuperProperties: map[string]interface{}{"channel": "a"}})
+
+	props := map[string]interface{}{"field1": 1, "field2": "2"}
+	// 同一个 Event 与 Mutation 在多个 goroutine 中上报
+	login := &Event{Name: "UserLogin", Props: props}
+	mutation := &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet, Props: props}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.Nil(t, c.ReportEvent(context.Background(), login))
+				assert.Nil(t, c.ReportMutation(context.Background(), mutation))
+				assert.Nil(t, c.ReportEvents(context.Background(), []*Event{{Name: "UserLogout", Props: props}, {Name: "UserLogout"}}))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, c.Close(context.Background()))
+
+	assert.Equal(t, map[string]interface{}{"field1": 1, "field2": "2"}, props)
+	assert.True(t, login.Time.IsZero())
+	assert.True(t, mutation.Time.IsZero())
+	assert.Len(t, p.data, 8*50*4)
+	logIds := make(map[interface{}]bool)
+	for _, data := range p.data {
+		logIds[dataOf(data)[internal.DataFieldNameLogId]] = true
+	}
+	assert.Len(t, logIds, len(p.data))
 }
@@ -0,0 +1,4 @@
+// Package events 演示通过 utilities/eventgen 根据事件目录生成的事件构造函数
+package events
+
+//go:generate go run github.com/funny/funnydb-go-sdk/v2/utilities/eventgen -input events.yaml -output events_gen.go -package events
@@ -0,0 +1,93 @@
+// Code generated by eventgen from events.yaml. DO NOT EDIT.
+
+package events
+
+import funnydb "github.com/funny/funnydb-go-sdk/v2"
+
+// EventUserLogin 事件名 UserLogin
+const EventUserLogin = "UserLogin"
+
+// UserLoginChannel UserLogin.channel 的取值
+type UserLoginChannel string
+
+const (
+	UserLoginChannelAppStore   UserLoginChannel = "app_store"
+	UserLoginChannelGooglePlay UserLoginChannel = "google_play"
+)
+
+// UserLoginOption 设置 UserLogin 的可选属性
+type UserLoginOption func(props map[string]interface{})
+
+// WithUserLoginIP 设置 UserLogin 的属性 #ip
+func WithUserLoginIP(v string) UserLoginOption {
+	return func(props map[string]interface{}) {
+		props["#ip"] = v
+	}
+}
+
+// WithUserLoginLevel 设置 UserLogin 的属性 level
+func WithUserLoginLevel(v int64) UserLoginOption {
+	return func(props map[string]interface{}) {
+		props["level"] = v
+	}
+}
+
+// NewUserLogin 创建 UserLogin 事件，参数依次为属性 #account_id, channel
+func NewUserLogin(accountID string, channel UserLoginChannel, opts ...UserLoginOption) *funnydb.Event {
+	props := map[string]interface{}{
+		"#account_id": accountID,
+		"channel":     string(channel),
+	}
+	for _, opt := range opts {
+		opt(props)
+	}
+	return &funnydb.Event{Name: EventUserLogin, Props: props}
+}
+
+// EventPaymentSucceeded 事件名 PaymentSucceeded
+const EventPaymentSucceeded = "PaymentSucceeded"
+
+// PaymentSucceededCurrency PaymentSucceeded.currency 的取值
+type PaymentSucceededCurrency string
+
+const (
+	PaymentSucceededCurrencyCNY PaymentSucceededCurrency = "CNY"
+	PaymentSucceededCurrencyUSD PaymentSucceededCurrency = "USD"
+)
+
+// PaymentSucceededOption 设置 PaymentSucceeded 的可选属性
+type PaymentSucceededOption func(props map[string]interface{})
+
+// WithPaymentSucceededCurrency 设置 PaymentSucceeded 的属性 currency，取值 CNY, USD
+func WithPaymentSucceededCurrency(v PaymentSucceededCurrency) PaymentSucceededOption {
+	return func(props map[string]interface{}) {
+		props["currency"] = string(v)
+	}
+}
+
+// WithPaymentSucceededFirstPay 设置 PaymentSucceeded 的属性 first_pay
+func WithPaymentSucceededFirstPay(v bool) PaymentSucceededOption {
+	return func(props map[string]interface{}) {
+		props["first_pay"] = v
+	}
+}
+
+// WithPaymentSucceededItems 设置 PaymentSucceeded 的属性 items
+func WithPaymentSucceededItems(v []interface{}) PaymentSucceededOption {
+	return func(props map[string]interface{}) {
+		props["items"] = v
+	}
+}
+
+// NewPaymentSucceeded 创建 PaymentSucceeded 事件，参数依次为属性 #account_id, order_id, amount
+func NewPaymentSucceeded(accountID string, orderID string, amount float64, opts ...PaymentSucceededOption) *funnydb.Event {
+	props := map[string]interface{}{
+		"#account_id": accountID,
+		"order_id":    orderID,
+		"amount":      amount,
+	}
+	for _, opt := range opts {
+		opt(props)
+	}
+	return &funnydb.Event{Name: EventPaymentSucceeded, Props: props}
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DeadLetterFileName 异步模式下无法发送的数据保存在 Directory 下的该文件中
+const DeadLetterFileName = "funnydb-deadletter.log"
+
+// deadLetter 保存单条就超过请求大小限制、无法发送的数据
+//
+// 每行一条数据，格式与 persist_only 模式的日志相同（配置了加密时同样加密），
+// 可以用 extract 工具的 -log 参数读取
+type deadLetter struct {
+	mu     sync.Mutex
+	path   string
+	cipher *RecordCipher
+}
+
+func newDeadLetter(directory string, cipher *RecordCipher) *deadLetter {
+	return &deadLetter{
+		path:   filepath.Join(directory, DeadLetterFileName),
+		cipher: cipher,
+	}
+}
+
+func (d *deadLetter) write(msgType string, data []byte) error {
+	line, err := marshalToBytes(map[string]interface{}{
+		"type": msgType,
+		"data": json.RawMessage(data),
+	})
+	if err == nil && d.cipher != nil {
+		line, err = encryptLogLine(d.cipher, line)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dirLockFileName      = "funnydb.lock"
+	dirLockRetryInterval = 100 * time.Millisecond
+)
+
+var ErrDirectoryLocked = errors.New("directory is locked by another producer")
+
+var errLockHeld = errors.New("lock is held")
+
+// DirLock 文件夹独占锁，防止多个进程（或同一进程内的多个 producer）同时使用同一个存储文件夹
+type DirLock struct {
+	file *os.File
+}
+
+// LockDirectory 对 directory 下的锁文件加锁（Unix 为 flock，Windows 为 LockFileEx），
+// 锁被占用时最多等待 timeout，超时返回包装了 ErrDirectoryLocked 的错误。
+// 其他平台不支持文件锁，只打印警告，不能防止多个 producer 同时使用同一个文件夹
+func LockDirectory(directory string, timeout time.Duration) (*DirLock, error) {
+	lockPath := filepath.Join(directory, dirLockFileName)
+	if !dirLockSupported {
+		DefaultLogger.Warnf("directory lock is not supported on this platform, make sure only one producer uses %s", directory)
+	}
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLockHeld) {
+			f.Close()
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			holder := readLockHolder(f)
+			f.Close()
+			return nil, fmt.Errorf("%w: %s (held by pid %s)", ErrDirectoryLocked, lockPath, holder)
+		}
+		time.Sleep(dirLockRetryInterval)
+	}
+
+	// 记录持有者 pid，便于排查
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &DirLock{file: f}, nil
+}
+
+func (l *DirLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+func readLockHolder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	holder := strings.TrimSpace(string(buf[:n]))
+	if holder == "" {
+		return "unknown"
+	}
+	return holder
+}
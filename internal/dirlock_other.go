@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package internal
+
+import "os"
+
+// 当前平台不支持文件锁，文件夹锁退化为空操作，LockDirectory 会打印警告
+const dirLockSupported = false
+
+func tryLockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
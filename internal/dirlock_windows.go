@@ -0,0 +1,51 @@
+//go:build windows
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const dirLockSupported = true
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockOverlapped 锁定文件末尾之后的一个字节，LockFileEx 是强制锁，
+// 不锁定文件内容以便其他进程读取持有者的 pid
+func lockOverlapped() *syscall.Overlapped {
+	return &syscall.Overlapped{OffsetHigh: 0x7fffffff}
+}
+
+func tryLockFile(f *os.File) error {
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(lockOverlapped())))
+	if r != 0 {
+		return nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(lockOverlapped())))
+	if r != 0 {
+		return nil
+	}
+	return err
+}
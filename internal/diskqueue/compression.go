@@ -0,0 +1,183 @@
+package diskqueue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec applied to every record payload of a data file.
+// It is stored in the flags of the file header, so a queue can hold files
+// written with different codecs and each one is read with its own.
+type Compression uint16
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionSnappy
+)
+
+var compressionNames = map[Compression]string{
+	CompressionNone:   "none",
+	CompressionGzip:   "gzip",
+	CompressionZstd:   "zstd",
+	CompressionSnappy: "snappy",
+}
+
+func (c Compression) String() string {
+	if name, ok := compressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("compression(%d)", uint16(c))
+}
+
+func (c Compression) valid() bool {
+	_, ok := compressionNames[c]
+	return ok
+}
+
+// ParseCompression returns the codec named name, an empty name means none
+func ParseCompression(name string) (Compression, error) {
+	if name == "" {
+		return CompressionNone, nil
+	}
+	for c, n := range compressionNames {
+		if n == name {
+			return c, nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q", name)
+}
+
+// WithCompression makes the queue write new data files with every record
+// payload compressed by c. Compressed files always use the checksummed
+// format, the checksum covers the compressed bytes.
+func WithCompression(c Compression) Option {
+	return func(d *diskQueue) {
+		if c == CompressionNone {
+			return
+		}
+		d.writeFormat.version = formatV1
+		d.writeFormat.compression = c
+	}
+}
+
+// maxCompressedSize bounds the stored size of a compressed payload whose
+// original size is at most maxMsgSize. Incompressible data grows a little,
+// snappy being the worst case with n + n/6 + 32 bytes.
+func maxCompressedSize(maxMsgSize int32) int32 {
+	return maxMsgSize + maxMsgSize/4 + 1024
+}
+
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} {
+			return gzip.NewWriter(nil)
+		},
+	}
+
+	zstdOnce     sync.Once
+	zstdEncoder  *zstd.Encoder
+	zstdDecoders sync.Map // maxMsgSize -> *zstd.Decoder
+)
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		// safe for concurrent use of EncodeAll
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+}
+
+// zstdDecoder returns a decoder (safe for concurrent use of DecodeAll) that
+// refuses to produce more than maxMsgSize bytes, so a corrupt or crafted
+// record fails before allocating its claimed size. Queues normally share
+// one maxMsgSize, so there is one decoder per process.
+func zstdDecoder(maxMsgSize int32) (*zstd.Decoder, error) {
+	if d, ok := zstdDecoders.Load(maxMsgSize); ok {
+		return d.(*zstd.Decoder), nil
+	}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxMsgSize)))
+	if err != nil {
+		return nil, err
+	}
+	if actual, loaded := zstdDecoders.LoadOrStore(maxMsgSize, d); loaded {
+		d.Close()
+		return actual.(*zstd.Decoder), nil
+	}
+	return d, nil
+}
+
+func (c Compression) compress(data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		initZstd()
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionSnappy:
+		return s2.EncodeSnappy(nil, data), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+}
+
+// decompress never returns more than maxMsgSize bytes
+func (c Compression) decompress(data []byte, maxMsgSize int32) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var r *gzip.Reader
+		r, err = gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			break
+		}
+		out, err = io.ReadAll(io.LimitReader(r, int64(maxMsgSize)+1))
+	case CompressionZstd:
+		var d *zstd.Decoder
+		d, err = zstdDecoder(maxMsgSize)
+		if err == nil {
+			out, err = d.DecodeAll(data, nil)
+		}
+	case CompressionSnappy:
+		var n int
+		n, err = s2.DecodedLen(data)
+		if err == nil && n > int(maxMsgSize) {
+			return nil, fmt.Errorf("invalid decompressed message size (%d)", n)
+		}
+		if err == nil {
+			out, err = s2.Decode(nil, data)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s decompression failed: %w", c, err)
+	}
+	if len(out) > int(maxMsgSize) {
+		return nil, fmt.Errorf("invalid decompressed message size (%d)", len(out))
+	}
+	return out, nil
+}
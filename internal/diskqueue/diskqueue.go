@@ -27,10 +27,8 @@ package diskqueue
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"os"
 	"path"
@@ -70,6 +68,7 @@ func (l LogLevel) String() string {
 
 type Interface interface {
 	Put([]byte) error
+	PutBatch([][]byte) error
 	ReadChan() <-chan []byte // this is expected to be an *unbuffered* channel
 	PeekChan() <-chan []byte // this is expected to be an *unbuffered* channel
 	Advance()
@@ -106,6 +105,20 @@ type diskQueue struct {
 	syncTimeout         time.Duration // duration of time per fsync
 	exitFlag            int32
 	needSync            bool
+	writeFormat         fileFormat // format of newly created data files
+
+	// format of the data file currently being read
+	readFileFormat fileFormat
+
+	// encryption of record payloads, see WithEncryption
+	cipher Cipher
+
+	// record level corruption recovery, see WithRecovery
+	recovery       bool
+	validate       func(data []byte) bool
+	skippedRegions int64
+	skippedRecords int64
+	skippedBytes   int64
 
 	// keeps track of the position where we have read
 	// (but not yet sent over readChan)
@@ -126,6 +139,7 @@ type diskQueue struct {
 	// internal channels
 	depthChan         chan int64
 	writeChan         chan []byte
+	writeBatchChan    chan [][]byte
 	writeResponseChan chan error
 	advanceChan       chan interface{}
 	emptyChan         chan int
@@ -142,7 +156,7 @@ type diskQueue struct {
 // from the filesystem and starting the read ahead goroutine
 func New(name string, dataPath string, maxBytesPerFile int64,
 	minMsgSize int32, maxMsgSize int32,
-	syncEvery int64, syncTimeout time.Duration, manualAdvance bool, logf AppLogFunc) Interface {
+	syncEvery int64, syncTimeout time.Duration, manualAdvance bool, logf AppLogFunc, opts ...Option) Interface {
 	d := diskQueue{
 		name:              name,
 		dataPath:          dataPath,
@@ -153,6 +167,7 @@ func New(name string, dataPath string, maxBytesPerFile int64,
 		peekChan:          make(chan []byte),
 		depthChan:         make(chan int64),
 		writeChan:         make(chan []byte),
+		writeBatchChan:    make(chan [][]byte),
 		writeResponseChan: make(chan error),
 		advanceChan:       make(chan interface{}),
 		emptyChan:         make(chan int),
@@ -165,6 +180,10 @@ func New(name string, dataPath string, maxBytesPerFile int64,
 		logf:              logf,
 	}
 
+	for _, opt := range opts {
+		opt(&d)
+	}
+
 	// no need to lock here, nothing else could possibly be touching this instance
 	err := d.retrieveMetaData()
 	if err != nil && !os.IsNotExist(err) {
@@ -211,6 +230,24 @@ func (d *diskQueue) Put(data []byte) error {
 	return <-d.writeResponseChan
 }
 
+// PutBatch writes several []byte to the queue in one round-trip with the
+// ioLoop, the fsync policy is applied once for the whole batch
+func (d *diskQueue) PutBatch(batch [][]byte) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.exitFlag == 1 {
+		return errors.New("exiting")
+	}
+
+	d.writeBatchChan <- batch
+	return <-d.writeResponseChan
+}
+
 // Close cleans up the queue and persists metadata
 func (d *diskQueue) Close() error {
 	err := d.exit(false)
@@ -274,10 +311,12 @@ func (d *diskQueue) Empty() error {
 func (d *diskQueue) deleteAllFiles() error {
 	err := d.skipToNextRWFile()
 
-	innerErr := os.Remove(d.metaDataFileName())
-	if innerErr != nil && !os.IsNotExist(innerErr) {
-		d.logf(ERROR, "DISKQUEUE(%s) failed to remove metadata file - %s", d.name, innerErr)
-		return innerErr
+	for _, fn := range []string{d.metaDataFileName(), d.prevMetaDataFileName()} {
+		innerErr := os.Remove(fn)
+		if innerErr != nil && !os.IsNotExist(innerErr) {
+			d.logf(ERROR, "DISKQUEUE(%s) failed to remove metadata file - %s", d.name, innerErr)
+			return innerErr
+		}
 	}
 
 	return err
@@ -323,7 +362,6 @@ func (d *diskQueue) skipToNextRWFile() error {
 // while advancing read positions and rolling files, if necessary
 func (d *diskQueue) readOne() ([]byte, error) {
 	var err error
-	var msgSize int32
 
 	if d.readFile != nil && d.readPos >= d.maxBytesPerFileRead {
 		d.readFile.Close()
@@ -353,6 +391,18 @@ func (d *diskQueue) readOne() ([]byte, error) {
 
 		d.logf(INFO, "DISKQUEUE(%s): readOne() opened %s", d.name, curFileName)
 
+		d.readFileFormat, err = readFileFormat(d.readFile)
+		if err != nil {
+			d.readFile.Close()
+			d.readFile = nil
+			return nil, err
+		}
+
+		// skip the file header, it is never sent to consumers
+		if d.readPos == 0 {
+			d.readPos = fileHeaderLen(d.readFileFormat)
+		}
+
 		if d.readPos > 0 {
 			_, err = d.readFile.Seek(d.readPos, 0)
 			if err != nil {
@@ -375,31 +425,16 @@ func (d *diskQueue) readOne() ([]byte, error) {
 		d.reader = bufio.NewReader(d.readFile)
 	}
 
-	err = binary.Read(d.reader, binary.BigEndian, &msgSize)
-	if err != nil {
-		d.readFile.Close()
-		d.readFile = nil
-		return nil, err
+	readBuf, totalBytes, err := readRecord(d.reader, d.readFileFormat, d.cipher, d.minMsgSize, d.maxMsgSize)
+	if err == nil && d.validate != nil && !d.validate(readBuf) {
+		err = fmt.Errorf("malformed message (size %d)", len(readBuf))
 	}
-
-	if msgSize < d.minMsgSize || msgSize > d.maxMsgSize {
-		// this file is corrupt and we have no reasonable guarantee on
-		// where a new message should begin
-		d.readFile.Close()
-		d.readFile = nil
-		return nil, fmt.Errorf("invalid message read size (%d)", msgSize)
-	}
-
-	readBuf := make([]byte, msgSize)
-	_, err = io.ReadFull(d.reader, readBuf)
 	if err != nil {
 		d.readFile.Close()
 		d.readFile = nil
 		return nil, err
 	}
 
-	totalBytes := int64(4 + msgSize)
-
 	// we only advance next* because we have not yet sent this to consumers
 	// (where readFileNum, readPos will actually be advanced)
 	d.nextReadPos = d.readPos + totalBytes
@@ -424,77 +459,122 @@ func (d *diskQueue) readOne() ([]byte, error) {
 // writeOne performs a low level filesystem write for a single []byte
 // while advancing write positions and rolling files, if necessary
 func (d *diskQueue) writeOne(data []byte) error {
-	var err error
-
-	dataLen := int32(len(data))
-	totalBytes := int64(4 + dataLen)
-
-	if dataLen < d.minMsgSize || dataLen > d.maxMsgSize {
-		return fmt.Errorf("invalid message write size (%d) minMsgSize=%d maxMsgSize=%d", dataLen, d.minMsgSize, d.maxMsgSize)
-	}
+	return d.writeMany([][]byte{data})
+}
 
-	// will not wrap-around if maxBytesPerFile + maxMsgSize < Int64Max
-	if d.writePos > 0 && d.writePos+totalBytes > d.maxBytesPerFile {
-		if d.readFileNum == d.writeFileNum {
-			d.maxBytesPerFileRead = d.writePos
+// writeMany writes contiguous records with a single write per data file.
+// Sizes are checked up front so an invalid message rejects the whole batch,
+// but an I/O error may leave a prefix of the batch written.
+func (d *diskQueue) writeMany(batch [][]byte) error {
+	for _, data := range batch {
+		dataLen := int32(len(data))
+		if dataLen < d.minMsgSize || dataLen > d.maxMsgSize {
+			return fmt.Errorf("invalid message write size (%d) minMsgSize=%d maxMsgSize=%d", dataLen, d.minMsgSize, d.maxMsgSize)
 		}
+	}
 
-		d.writeFileNum++
-		d.writePos = 0
+	// bytes and messages buffered in writeBuf but not yet written
+	var pendingBytes, pendingMsgs int64
 
-		// sync every time we start writing to a new file
-		err = d.sync()
-		if err != nil {
-			d.logf(ERROR, "DISKQUEUE(%s) failed to sync - %s", d.name, err)
+	flush := func() error {
+		if pendingBytes == 0 {
+			return nil
 		}
 
-		if d.writeFile != nil {
+		// only write to the file once
+		_, err := d.writeFile.Write(d.writeBuf.Bytes())
+		if err != nil {
 			d.writeFile.Close()
 			d.writeFile = nil
+			return err
 		}
+
+		d.writePos += pendingBytes
+		d.depth += pendingMsgs
+		pendingBytes = 0
+		pendingMsgs = 0
+		d.writeBuf.Reset()
+		return nil
 	}
-	if d.writeFile == nil {
-		curFileName := d.fileName(d.writeFileNum)
-		d.writeFile, err = os.OpenFile(curFileName, os.O_RDWR|os.O_CREATE, 0600)
+
+	d.writeBuf.Reset()
+	for _, data := range batch {
+		payload, err := d.writeFormat.encodePayload(data, d.cipher)
 		if err != nil {
 			return err
 		}
+		totalBytes := recordOverhead(d.writeFormat) + int64(len(payload))
+
+		// never append to an existing file written in another format
+		if d.writeFile == nil && d.writePos > 0 {
+			fileName := d.fileName(d.writeFileNum)
+			format, err := fileFormatOf(fileName)
+			if err != nil || format != d.writeFormat {
+				d.logf(INFO, "DISKQUEUE(%s) %s format %v differs from %v, skipping to new file",
+					d.name, fileName, format, d.writeFormat)
+				d.rollWriteFile()
+			}
+		}
 
-		d.logf(INFO, "DISKQUEUE(%s): writeOne() opened %s", d.name, curFileName)
-
-		if d.writePos > 0 {
-			_, err = d.writeFile.Seek(d.writePos, 0)
+		// will not wrap-around if maxBytesPerFile + maxMsgSize < Int64Max
+		pos := d.writePos + pendingBytes
+		if pos > 0 && pos+totalBytes > d.maxBytesPerFile {
+			err = flush()
 			if err != nil {
-				d.writeFile.Close()
-				d.writeFile = nil
 				return err
 			}
+			d.rollWriteFile()
 		}
+		if d.writeFile == nil {
+			curFileName := d.fileName(d.writeFileNum)
+			d.writeFile, err = os.OpenFile(curFileName, os.O_RDWR|os.O_CREATE, 0600)
+			if err != nil {
+				return err
+			}
+
+			d.logf(INFO, "DISKQUEUE(%s): writeOne() opened %s", d.name, curFileName)
+
+			if d.writePos > 0 {
+				_, err = d.writeFile.Seek(d.writePos, 0)
+				if err != nil {
+					d.writeFile.Close()
+					d.writeFile = nil
+					return err
+				}
+			}
+		}
+
+		if d.writePos+pendingBytes == 0 && d.writeFormat.version != formatV0 {
+			writeFileHeader(&d.writeBuf, d.writeFormat)
+			totalBytes += fileHeaderLen(d.writeFormat)
+		}
+		writeRecord(&d.writeBuf, d.writeFormat, payload)
+		pendingBytes += totalBytes
+		pendingMsgs++
 	}
 
-	d.writeBuf.Reset()
-	err = binary.Write(&d.writeBuf, binary.BigEndian, dataLen)
-	if err != nil {
-		return err
+	return flush()
+}
+
+// rollWriteFile moves writes to the next data file
+func (d *diskQueue) rollWriteFile() {
+	if d.readFileNum == d.writeFileNum {
+		d.maxBytesPerFileRead = d.writePos
 	}
 
-	_, err = d.writeBuf.Write(data)
+	d.writeFileNum++
+	d.writePos = 0
+
+	// sync every time we start writing to a new file
+	err := d.sync()
 	if err != nil {
-		return err
+		d.logf(ERROR, "DISKQUEUE(%s) failed to sync - %s", d.name, err)
 	}
 
-	// only write to the file once
-	_, err = d.writeFile.Write(d.writeBuf.Bytes())
-	if err != nil {
+	if d.writeFile != nil {
 		d.writeFile.Close()
 		d.writeFile = nil
-		return err
 	}
-
-	d.writePos += totalBytes
-	d.depth += 1
-
-	return err
 }
 
 // sync fsyncs the current writeFile and persists metadata
@@ -519,25 +599,19 @@ func (d *diskQueue) sync() error {
 
 // retrieveMetaData initializes state from the filesystem
 func (d *diskQueue) retrieveMetaData() error {
-	var f *os.File
-	var err error
-
-	fileName := d.metaDataFileName()
-	f, err = os.OpenFile(fileName, os.O_RDONLY, 0600)
+	m, err := loadMetaData(d.metaDataFileName())
 	if err != nil {
-		return err
+		m, err = d.recoverMetaData(err)
+		if err != nil {
+			return err
+		}
 	}
-	defer f.Close()
 
-	var depth int64
-	_, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
-		&depth,
-		&d.readFileNum, &d.readPos,
-		&d.writeFileNum, &d.writePos)
-	if err != nil {
-		return err
-	}
-	d.depth = depth
+	d.depth = m.depth
+	d.readFileNum = m.readFileNum
+	d.readPos = m.readPos
+	d.writeFileNum = m.writeFileNum
+	d.writePos = m.writePos
 	d.nextReadFileNum = d.readFileNum
 	d.nextReadPos = d.readPos
 	d.ackFileNum = d.readFileNum
@@ -548,7 +622,7 @@ func (d *diskQueue) retrieveMetaData() error {
 	// in which case the safest thing to do is skip to the next file for
 	// writes, and let the reader salvage what it can from the messages in the
 	// diskqueue beyond the metadata's likely also stale readPos
-	fileName = d.fileName(d.writeFileNum)
+	fileName := d.fileName(d.writeFileNum)
 	fileInfo, err := os.Stat(fileName)
 	if err != nil {
 		return err
@@ -593,10 +667,13 @@ func (d *diskQueue) persistMetaData() error {
 		depth = d.depth + d.unacked
 	}
 
-	_, err = fmt.Fprintf(f, "%d\n%d,%d\n%d,%d\n",
-		depth,
-		readFileNum, readPos,
-		d.writeFileNum, d.writePos)
+	_, err = f.Write(metaData{
+		depth:        depth,
+		readFileNum:  readFileNum,
+		readPos:      readPos,
+		writeFileNum: d.writeFileNum,
+		writePos:     d.writePos,
+	}.encode())
 	if err != nil {
 		f.Close()
 		return err
@@ -604,6 +681,12 @@ func (d *diskQueue) persistMetaData() error {
 	f.Sync()
 	f.Close()
 
+	// keep the previous generation as a fallback
+	err = os.Rename(fileName, d.prevMetaDataFileName())
+	if err != nil && !os.IsNotExist(err) {
+		d.logf(ERROR, "DISKQUEUE(%s) failed to keep previous metadata - %s", d.name, err)
+	}
+
 	// atomically rename
 	return os.Rename(tmpFileName, fileName)
 }
@@ -612,6 +695,10 @@ func (d *diskQueue) metaDataFileName() string {
 	return fmt.Sprintf(path.Join(d.dataPath, "%s.diskqueue.meta.dat"), d.name)
 }
 
+func (d *diskQueue) prevMetaDataFileName() string {
+	return d.metaDataFileName() + ".prev"
+}
+
 func (d *diskQueue) fileName(fileNum int64) string {
 	return fmt.Sprintf(path.Join(d.dataPath, "%s.diskqueue.%06d.dat"), d.name, fileNum)
 }
@@ -760,7 +847,7 @@ func (d *diskQueue) ioLoop() {
 
 	for {
 		// dont sync all the time :)
-		if count == d.syncEvery {
+		if count >= d.syncEvery {
 			d.needSync = true
 		}
 
@@ -778,7 +865,9 @@ func (d *diskQueue) ioLoop() {
 				if err != nil {
 					d.logf(ERROR, "DISKQUEUE(%s) reading at %d of %s - %s",
 						d.name, d.readPos, d.fileName(d.readFileNum), err)
-					d.handleReadError()
+					if !d.recoverReadError() {
+						d.handleReadError()
+					}
 					continue
 				}
 			}
@@ -803,6 +892,9 @@ func (d *diskQueue) ioLoop() {
 		case dataWrite := <-d.writeChan:
 			count++
 			d.writeResponseChan <- d.writeOne(dataWrite)
+		case batchWrite := <-d.writeBatchChan:
+			count += int64(len(batchWrite))
+			d.writeResponseChan <- d.writeMany(batchWrite)
 		case <-d.advanceChan:
 			d.advance()
 		case <-syncTicker.C:
@@ -27,7 +27,12 @@ package diskqueue
 import (
 	"bufio"
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -38,6 +43,8 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func Equal(t *testing.T, expected, actual interface{}) {
@@ -634,6 +641,550 @@ func TestDiskQueueCorruption(t *testing.T) {
 	Equal(t, int64(0), dq.Depth())
 }
 
+// 测试批量写入，跨文件滚动时保持顺序
+func TestDiskQueuePutBatch(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_put_batch" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+	ml := int64(len(msg))
+	dq := New(dqName, tmpDir, 10*(ml+4), int32(ml), 1<<10, 2500, 2*time.Second, false, l)
+	defer dq.Close()
+
+	batch := make([][]byte, 25)
+	for i := range batch {
+		batch[i] = append([]byte{byte(i)}, msg[1:]...)
+	}
+	Nil(t, dq.PutBatch(batch))
+	Equal(t, int64(25), dq.Depth())
+
+	// 10 messages per file
+	Equal(t, int64(2), dq.(*diskQueue).writeFileNum)
+	Equal(t, 5*(ml+4), dq.(*diskQueue).writePos)
+
+	// an invalid message rejects the whole batch
+	NotNil(t, dq.PutBatch([][]byte{msg, {1}}))
+	Equal(t, int64(25), dq.Depth())
+
+	for i := range batch {
+		Equal(t, batch[i], <-dq.ReadChan())
+	}
+	Equal(t, int64(0), dq.Depth())
+}
+
+// 测试带校验的记录格式
+func TestDiskQueueChecksum(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_checksum" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := []byte("test")
+	dq := New(dqName, tmpDir, 1024, 4, 1<<10, 2500, 2*time.Second, false, l, WithChecksum())
+	defer dq.Close()
+
+	for i := 0; i < 3; i++ {
+		Nil(t, dq.Put(msg))
+	}
+	Equal(t, int64(3), dq.Depth())
+
+	// 文件头 + 3 条 (长度 + 校验 + 数据)
+	Equal(t, int64(fileHeaderSize+3*(8+len(msg))), dq.(*diskQueue).writePos)
+	data, err := os.ReadFile(dq.(*diskQueue).fileName(0))
+	Nil(t, err)
+	Equal(t, fileHeaderMagic, data[:len(fileHeaderMagic)])
+
+	for i := 0; i < 3; i++ {
+		Equal(t, msg, <-dq.ReadChan())
+	}
+	Equal(t, int64(0), dq.Depth())
+}
+
+// 测试开启校验后仍能读取旧格式的队列文件
+func TestDiskQueueChecksumUpgrade(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_checksum_upgrade" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := []byte("0123456789")
+
+	dq := New(dqName, tmpDir, 1024, 1, 1<<10, 2500, 2*time.Second, true, l)
+	for i := 0; i < 3; i++ {
+		msg[0] = byte(i)
+		Nil(t, dq.Put(msg))
+	}
+	dq.Close()
+
+	dq = New(dqName, tmpDir, 1024, 1, 1<<10, 2500, 2*time.Second, true, l, WithChecksum())
+	defer dq.Close()
+	for i := 3; i < 5; i++ {
+		msg[0] = byte(i)
+		Nil(t, dq.Put(msg))
+	}
+
+	// 旧格式文件不会被追加写入
+	Equal(t, int64(1), dq.(*diskQueue).writeFileNum)
+	Equal(t, int64(5), dq.Depth())
+
+	for i := 0; i < 5; i++ {
+		msg[0] = byte(i)
+		Equal(t, msg, <-dq.ReadChan())
+	}
+
+	m, err := filepath.Glob(filepath.Join(tmpDir, "*.bad"))
+	Nil(t, err)
+	Equal(t, 0, len(m))
+}
+
+// 测试压缩格式，以及升级后未压缩的旧文件仍然可读
+func TestDiskQueueCompression(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_compression" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := bytes.Repeat([]byte(`{"#event":"UserLogin","level":1}`), 8)
+
+	dq := New(dqName, tmpDir, 1<<20, 1, 1<<10, 2500, 2*time.Second, true, l, WithChecksum())
+	Nil(t, dq.Put(msg))
+	dq.Close()
+
+	for i, c := range []Compression{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		dq = New(dqName, tmpDir, 1<<20, 1, 1<<10, 2500, 2*time.Second, true, l, WithCompression(c))
+		Nil(t, dq.PutBatch([][]byte{msg, msg}))
+
+		// 每种压缩算法写入新的文件
+		fn := dq.(*diskQueue).fileName(int64(i + 1))
+		format, err := fileFormatOf(fn)
+		Nil(t, err)
+		Equal(t, fileFormat{version: formatV1, compression: c}, format)
+
+		stat, err := os.Stat(fn)
+		Nil(t, err)
+		Equal(t, true, stat.Size() < int64(len(msg)))
+		dq.Close()
+	}
+
+	dq = New(dqName, tmpDir, 1<<20, 1, 1<<10, 2500, 2*time.Second, true, l)
+	defer dq.Close()
+	Equal(t, int64(7), dq.Depth())
+	for i := 0; i < 7; i++ {
+		Equal(t, msg, <-dq.ReadChan())
+	}
+
+	m, err := filepath.Glob(filepath.Join(tmpDir, "*.bad"))
+	Nil(t, err)
+	Equal(t, 0, len(m))
+}
+
+type testCipher struct {
+	aead cipher.AEAD
+}
+
+func newTestCipher() *testCipher {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &testCipher{aead: aead}
+}
+
+func (c *testCipher) Encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (c *testCipher) Decrypt(data []byte) ([]byte, error) {
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("short ciphertext")
+	}
+	return c.aead.Open(nil, data[:c.aead.NonceSize()], data[c.aead.NonceSize():], nil)
+}
+
+// 测试加密格式，以及开启加密后未加密的旧文件仍然可读
+func TestDiskQueueEncryption(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_encryption" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := []byte(`{"#event":"UserLogin","#ip":"123.23.11.155"}`)
+	c := newTestCipher()
+
+	dq := New(dqName, tmpDir, 1<<20, 1, 1<<10, 2500, 2*time.Second, true, l)
+	Nil(t, dq.Put(msg))
+	dq.Close()
+
+	dq = New(dqName, tmpDir, 1<<20, 1, 1<<10, 2500, 2*time.Second, true, l,
+		WithCompression(CompressionSnappy), WithEncryption(c))
+	Nil(t, dq.PutBatch([][]byte{msg, msg}))
+	dq.Close()
+
+	fn := dq.(*diskQueue).fileName(1)
+	format, err := fileFormatOf(fn)
+	Nil(t, err)
+	Equal(t, fileFormat{version: formatV1, compression: CompressionSnappy, encrypted: true}, format)
+	b, err := os.ReadFile(fn)
+	Nil(t, err)
+	Equal(t, false, bytes.Contains(b, []byte("123.23.11.155")))
+
+	// 离线读取需要设置 Cipher
+	f, err := os.Open(fn)
+	Nil(t, err)
+	defer f.Close()
+	_, err = NewReader(f, 1<<10).Next()
+	Equal(t, true, errors.Is(err, ErrNoCipher))
+	_, err = f.Seek(0, io.SeekStart)
+	Nil(t, err)
+	r := NewReader(f, 1<<10)
+	r.SetCipher(c)
+	data, err := r.Next()
+	Nil(t, err)
+	Equal(t, msg, data)
+
+	dq = New(dqName, tmpDir, 1<<20, 1, 1<<10, 2500, 2*time.Second, true, l, WithEncryption(c))
+	defer dq.Close()
+	Equal(t, int64(3), dq.Depth())
+	for i := 0; i < 3; i++ {
+		Equal(t, msg, <-dq.ReadChan())
+	}
+}
+
+// 解压后超过 maxMsgSize 的记录在分配完整大小之前返回错误
+func TestDecompressLimit(t *testing.T) {
+	bomb := make([]byte, 64<<20)
+	for _, c := range []Compression{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		compressed, err := c.compress(bomb)
+		Nil(t, err)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err = c.decompress(compressed, 1<<10)
+		runtime.ReadMemStats(&after)
+		NotNil(t, err)
+		Equal(t, true, after.TotalAlloc-before.TotalAlloc < uint64(len(bomb))/4)
+
+		data, err := c.decompress(compressed, int32(len(bomb)))
+		Nil(t, err)
+		Equal(t, len(bomb), len(data))
+	}
+
+	// 没有记录原始大小的 zstd 帧同样受限制
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	Nil(t, err)
+	_, err = w.Write(bomb)
+	Nil(t, err)
+	Nil(t, w.Close())
+	_, err = CompressionZstd.decompress(buf.Bytes(), 1<<10)
+	NotNil(t, err)
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, name := range []string{"none", "gzip", "zstd", "snappy"} {
+		c, err := ParseCompression(name)
+		Nil(t, err)
+		Equal(t, name, c.String())
+	}
+	c, err := ParseCompression("")
+	Nil(t, err)
+	Equal(t, CompressionNone, c)
+	_, err = ParseCompression("lz4")
+	NotNil(t, err)
+}
+
+// 测试记录内容损坏（长度合法）时能被校验发现
+func TestDiskQueueChecksumCorruption(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_checksum_corruption" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dq := New(dqName, tmpDir, 1000, 10, 1<<10, 5, 2*time.Second, false, l, WithChecksum())
+	defer dq.Close()
+
+	msg := make([]byte, 120) // 128 bytes per message, 7 messages (8 + 896 bytes) per file
+	msg[0] = 91
+	msg[119] = 211
+
+	for i := 0; i < 14; i++ {
+		dq.Put(msg)
+	}
+	Equal(t, int64(1), dq.(*diskQueue).writeFileNum)
+
+	// flip a payload byte of the 4th message in the 1st file
+	dqFn := dq.(*diskQueue).fileName(0)
+	f, err := os.OpenFile(dqFn, os.O_RDWR, 0600)
+	Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff}, fileHeaderSize+3*128+8+60)
+	Nil(t, err)
+	f.Close()
+
+	// 3 valid messages in the 1st file, 7 in the 2nd file
+	for i := 0; i < 10; i++ {
+		Equal(t, msg, <-dq.ReadChan())
+	}
+
+	m, err := filepath.Glob(filepath.Join(tmpDir, "*.bad"))
+	Nil(t, err)
+	Equal(t, 1, len(m))
+}
+
+// 测试记录级别的损坏恢复，只丢弃损坏的记录
+func TestDiskQueueRecovery(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_recovery" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dq := New(dqName, tmpDir, 1000, 10, 1<<10, 5, 2*time.Second, false, l, WithChecksum(), WithRecovery(nil))
+	defer dq.Close()
+
+	msg := make([]byte, 120) // 128 bytes per message, 7 messages (8 + 896 bytes) per file
+	for i := 0; i < 17; i++ {
+		msg[0] = byte(i)
+		dq.Put(msg)
+	}
+	Equal(t, int64(2), dq.(*diskQueue).writeFileNum)
+
+	corrupt := func(fileNum int64, offset int64) {
+		f, err := os.OpenFile(dq.(*diskQueue).fileName(fileNum), os.O_RDWR, 0600)
+		Nil(t, err)
+		_, err = f.WriteAt([]byte{0xff}, offset)
+		Nil(t, err)
+		f.Close()
+	}
+	// payload of the 4th message in the 1st file
+	corrupt(0, fileHeaderSize+3*128+8+60)
+	// payloads of the 3rd and 4th messages in the 2nd file
+	corrupt(1, fileHeaderSize+2*128+8+60)
+	corrupt(1, fileHeaderSize+3*128+8+60)
+	// length of the 2nd message in the 3rd (current) file
+	corrupt(2, fileHeaderSize+1*128)
+
+	read := 0
+	for _, i := range []int{0, 1, 2, 4, 5, 6, 7, 8, 11, 12, 13, 14, 16} {
+		msg[0] = byte(i)
+		Equal(t, msg, <-dq.ReadChan())
+		read++
+
+		// skipped records are no longer counted in depth
+		switch i {
+		case 4:
+			Equal(t, int64(17-read-1), dq.Depth())
+		case 11:
+			Equal(t, int64(17-read-3), dq.Depth())
+		}
+	}
+	Equal(t, int64(4), dq.(*diskQueue).skippedRecords)
+
+	// the current file is still used for writing
+	msg[0] = 17
+	Nil(t, dq.Put(msg))
+	Equal(t, msg, <-dq.ReadChan())
+	Equal(t, int64(2), dq.(*diskQueue).writeFileNum)
+
+	m, err := filepath.Glob(filepath.Join(tmpDir, "*.bad"))
+	Nil(t, err)
+	Equal(t, 3, len(m))
+	var size int64
+	for _, fn := range m {
+		stat, err := os.Stat(fn)
+		Nil(t, err)
+		size += stat.Size()
+	}
+	Equal(t, int64(4*128), size)
+}
+
+// 测试旧格式队列文件通过内容校验函数恢复
+func TestDiskQueueRecoveryLegacy(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_recovery_legacy" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	isObject := func(data []byte) bool {
+		return data[0] == '{' && data[len(data)-1] == '}'
+	}
+	dq := New(dqName, tmpDir, 1<<20, 1, 1<<10, 5, 2*time.Second, false, l, WithRecovery(isObject))
+	defer dq.Close()
+
+	for i := 0; i < 5; i++ {
+		dq.Put([]byte(fmt.Sprintf(`{"i":%d}`, i)))
+	}
+	recordSize := int64(4 + len(`{"i":0}`))
+
+	// give the 3rd message a plausible but wrong length
+	f, err := os.OpenFile(dq.(*diskQueue).fileName(0), os.O_RDWR, 0600)
+	Nil(t, err)
+	_, err = f.WriteAt([]byte{0, 0, 0, 3}, 2*recordSize)
+	Nil(t, err)
+	f.Close()
+
+	for _, i := range []int{0, 1, 3, 4} {
+		Equal(t, []byte(fmt.Sprintf(`{"i":%d}`, i)), <-dq.ReadChan())
+	}
+}
+
+// 测试离线读取各种格式的队列文件
+func TestReader(t *testing.T) {
+	formats := []fileFormat{
+		{version: formatV0},
+		{version: formatV1},
+		{version: formatV1, compression: CompressionGzip},
+		{version: formatV1, compression: CompressionZstd},
+		{version: formatV1, compression: CompressionSnappy},
+	}
+	for _, format := range formats {
+		var buf bytes.Buffer
+		if format.version != formatV0 {
+			writeFileHeader(&buf, format)
+		}
+		for _, msg := range []string{"hello", "world"} {
+			payload, err := format.encodePayload([]byte(msg), nil)
+			Nil(t, err)
+			writeRecord(&buf, format, payload)
+		}
+
+		r := NewReader(bytes.NewReader(buf.Bytes()), 1<<10)
+		data, err := r.Next()
+		Nil(t, err)
+		Equal(t, []byte("hello"), data)
+		data, err = r.Next()
+		Nil(t, err)
+		Equal(t, []byte("world"), data)
+		_, err = r.Next()
+		Equal(t, io.EOF, err)
+	}
+
+	var buf bytes.Buffer
+	writeFileHeader(&buf, fileFormat{version: formatV1})
+	writeRecord(&buf, fileFormat{version: formatV1}, []byte("hello"))
+	corrupted := buf.Bytes()
+	corrupted[len(corrupted)-1] = 'x'
+
+	r := NewReader(bytes.NewReader(corrupted), 1<<10)
+	_, err := r.Next()
+	Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+}
+
+// 测试元数据文件损坏时回退到上一版本
+func TestDiskQueueMetaDataFallback(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_metadata_fallback" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := []byte("0123456789")
+	dq := New(dqName, tmpDir, 1024, 1, 1<<10, 2500, 2*time.Second, false, l)
+	for i := 0; i < 5; i++ {
+		Nil(t, dq.Put(msg))
+	}
+	<-dq.ReadChan()
+	dq.Close()
+
+	// every sync keeps the previous generation
+	dq = New(dqName, tmpDir, 1024, 1, 1<<10, 2500, 2*time.Second, false, l)
+	dq.Close()
+
+	metaFn := dq.(*diskQueue).metaDataFileName()
+	prevFn := dq.(*diskQueue).prevMetaDataFileName()
+	m, err := loadMetaData(metaFn)
+	Nil(t, err)
+	Equal(t, metaData{depth: 4, readFileNum: 0, readPos: 14, writeFileNum: 0, writePos: 70}, m)
+	_, err = loadMetaData(prevFn)
+	Nil(t, err)
+
+	// flip a digit of the depth, the checksum no longer matches
+	data, err := os.ReadFile(metaFn)
+	Nil(t, err)
+	data[0] = '9'
+	Nil(t, os.WriteFile(metaFn, data, 0600))
+	_, err = loadMetaData(metaFn)
+	Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+
+	dq = New(dqName, tmpDir, 1024, 1, 1<<10, 2500, 2*time.Second, false, l)
+	defer dq.Close()
+	Equal(t, int64(4), dq.Depth())
+	for i := 0; i < 4; i++ {
+		Equal(t, msg, <-dq.ReadChan())
+	}
+}
+
+// 测试元数据文件全部丢失时通过扫描数据文件重建
+func TestDiskQueueMetaDataReconstruct(t *testing.T) {
+	l := NewTestLogger(t)
+	dqName := "test_disk_queue_metadata_reconstruct" + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	msg := make([]byte, 120) // 124 bytes per message, 8 messages (992 bytes) per file
+	dq := New(dqName, tmpDir, 1000, 10, 1<<10, 2500, 2*time.Second, false, l)
+	for i := 0; i < 20; i++ {
+		msg[0] = byte(i)
+		Nil(t, dq.Put(msg))
+	}
+	// reading the whole 1st file removes it
+	for i := 0; i < 8; i++ {
+		<-dq.ReadChan()
+	}
+	dq.Close()
+
+	Nil(t, os.WriteFile(dq.(*diskQueue).metaDataFileName(), []byte("garbage"), 0600))
+	Nil(t, os.Remove(dq.(*diskQueue).prevMetaDataFileName()))
+
+	dq = New(dqName, tmpDir, 1000, 10, 1<<10, 2500, 2*time.Second, false, l)
+	defer dq.Close()
+	Equal(t, int64(12), dq.Depth())
+	Equal(t, int64(3), dq.(*diskQueue).writeFileNum)
+
+	for i := 8; i < 20; i++ {
+		msg[0] = byte(i)
+		Equal(t, msg, <-dq.ReadChan())
+	}
+	Equal(t, int64(0), dq.Depth())
+}
+
+// 测试兼容旧版本（无校验）的元数据文件
+func TestDiskQueueLegacyMetaData(t *testing.T) {
+	m, err := decodeMetaData([]byte("3\n1,28\n2,14\n"))
+	Nil(t, err)
+	Equal(t, metaData{depth: 3, readFileNum: 1, readPos: 28, writeFileNum: 2, writePos: 14}, m)
+
+	m, err = decodeMetaData(m.encode())
+	Nil(t, err)
+	Equal(t, metaData{depth: 3, readFileNum: 1, readPos: 28, writeFileNum: 2, writePos: 14}, m)
+}
+
 type md struct {
 	depth        int64
 	readFileNum  int64
@@ -1025,6 +1576,39 @@ func benchmarkDiskQueuePut(size int64, b *testing.B) {
 	}
 }
 
+func BenchmarkDiskQueuePutBatch256(b *testing.B) {
+	benchmarkDiskQueuePutBatch(256, 100, b)
+}
+func BenchmarkDiskQueuePutBatch1024(b *testing.B) {
+	benchmarkDiskQueuePutBatch(1024, 100, b)
+}
+
+func benchmarkDiskQueuePutBatch(size int64, batchSize int, b *testing.B) {
+	b.StopTimer()
+	l := NewTestLogger(b)
+	dqName := "bench_disk_queue_put_batch" + strconv.Itoa(b.N) + strconv.Itoa(int(time.Now().Unix()))
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("nsq-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dq := New(dqName, tmpDir, 1024768*100, 0, 1<<20, 2500, 2*time.Second, false, l)
+	defer dq.Close()
+	b.SetBytes(size)
+	batch := make([][]byte, batchSize)
+	for i := range batch {
+		batch[i] = make([]byte, size)
+	}
+	b.StartTimer()
+
+	for i := 0; i < b.N; i += batchSize {
+		err := dq.PutBatch(batch[:min(batchSize, b.N-i)])
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 func BenchmarkDiskWrite16(b *testing.B) {
 	benchmarkDiskWrite(16, b)
 }
@@ -0,0 +1,30 @@
+package diskqueue
+
+import "errors"
+
+// MaxCipherOverhead is the most bytes a Cipher may add to a payload
+const MaxCipherOverhead = 1024
+
+var ErrNoCipher = errors.New("data file is encrypted but no cipher is configured")
+
+// Cipher encrypts record payloads at rest. Implementations must be safe for
+// concurrent use and should authenticate the data, so that a corrupt record
+// fails to decrypt.
+type Cipher interface {
+	Encrypt(data []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// WithEncryption makes the queue write new data files with every record
+// payload encrypted by c, after compression if any. Data files written
+// before, encrypted or not, stay readable as long as c can decrypt them.
+func WithEncryption(c Cipher) Option {
+	return func(d *diskQueue) {
+		d.cipher = c
+		if c == nil {
+			return
+		}
+		d.writeFormat.version = formatV1
+		d.writeFormat.encrypted = true
+	}
+}
@@ -0,0 +1,286 @@
+package diskqueue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Data file formats.
+//
+// formatV0 is the original nsq layout: no file header, and every record is a
+// 4-byte big-endian length followed by the payload.
+//
+// formatV1 files start with an 8-byte header (4-byte magic, 2-byte version,
+// 2-byte flags) and every record is a 4-byte length, a 4-byte CRC32C of the
+// stored payload, then the stored payload. The first magic byte has the sign
+// bit set so a formatV1 header can never be mistaken for a formatV0 length
+// prefix, which keeps queues written by older versions readable. The low byte
+// of the flags holds the Compression of the payloads and flagEncrypted marks
+// payloads encrypted by a Cipher after compression, the other bits must be 0.
+const (
+	formatV0 = 0
+	formatV1 = 1
+
+	fileHeaderSize = 8
+
+	flagsCompressionMask = 0x00ff
+	flagEncrypted        = 0x0100
+)
+
+// fileFormat describes how the records of a data file are laid out
+type fileFormat struct {
+	version     uint16
+	compression Compression
+	encrypted   bool
+}
+
+func (f fileFormat) String() string {
+	s := fmt.Sprintf("v%d", f.version)
+	if f.compression != CompressionNone {
+		s += "/" + f.compression.String()
+	}
+	if f.encrypted {
+		s += "/encrypted"
+	}
+	return s
+}
+
+func (f fileFormat) flags() uint16 {
+	flags := uint16(f.compression)
+	if f.encrypted {
+		flags |= flagEncrypted
+	}
+	return flags
+}
+
+var fileHeaderMagic = []byte{0xFD, 'F', 'D', 'Q'}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Option configures optional behaviour of a diskQueue
+type Option func(*diskQueue)
+
+// WithChecksum makes the queue write new data files in the checksummed
+// format, files written before are still read in their own format
+func WithChecksum() Option {
+	return func(d *diskQueue) {
+		d.writeFormat.version = formatV1
+	}
+}
+
+// parseFileHeader returns the format of a data file given (up to) its first
+// fileHeaderSize bytes
+func parseFileHeader(hdr []byte) (fileFormat, error) {
+	if len(hdr) < len(fileHeaderMagic) || !bytes.Equal(hdr[:len(fileHeaderMagic)], fileHeaderMagic) {
+		return fileFormat{version: formatV0}, nil
+	}
+	if len(hdr) < fileHeaderSize {
+		return fileFormat{}, fmt.Errorf("truncated file header (%d bytes)", len(hdr))
+	}
+	version := binary.BigEndian.Uint16(hdr[4:6])
+	if version != formatV1 {
+		return fileFormat{}, fmt.Errorf("unsupported file format version (%d)", version)
+	}
+	flags := binary.BigEndian.Uint16(hdr[6:8])
+	compression := Compression(flags & flagsCompressionMask)
+	if flags&^(flagsCompressionMask|flagEncrypted) != 0 || !compression.valid() {
+		return fileFormat{}, fmt.Errorf("unsupported file flags (0x%04x)", flags)
+	}
+	return fileFormat{
+		version:     version,
+		compression: compression,
+		encrypted:   flags&flagEncrypted != 0,
+	}, nil
+}
+
+func readFileFormat(r io.ReaderAt) (fileFormat, error) {
+	hdr := make([]byte, fileHeaderSize)
+	n, err := r.ReadAt(hdr, 0)
+	if err != nil && err != io.EOF {
+		return fileFormat{}, err
+	}
+	return parseFileHeader(hdr[:n])
+}
+
+func fileFormatOf(fileName string) (fileFormat, error) {
+	f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+	if err != nil {
+		return fileFormat{}, err
+	}
+	defer f.Close()
+	return readFileFormat(f)
+}
+
+func writeFileHeader(buf *bytes.Buffer, format fileFormat) {
+	buf.Write(fileHeaderMagic)
+	binary.Write(buf, binary.BigEndian, format.version)
+	binary.Write(buf, binary.BigEndian, format.flags())
+}
+
+// fileHeaderLen is the number of bytes preceding the first record
+func fileHeaderLen(format fileFormat) int64 {
+	if format.version == formatV0 {
+		return 0
+	}
+	return fileHeaderSize
+}
+
+// recordOverhead is the number of bytes preceding the payload of a record
+func recordOverhead(format fileFormat) int64 {
+	if format.version == formatV0 {
+		return 4
+	}
+	return 8
+}
+
+// maxStoredSize is the largest payload a record of this format may store
+func (f fileFormat) maxStoredSize(maxMsgSize int32) int32 {
+	size := maxMsgSize
+	if f.compression != CompressionNone {
+		size = maxCompressedSize(maxMsgSize)
+	}
+	if f.encrypted {
+		size += MaxCipherOverhead
+	}
+	return size
+}
+
+// transformed reports whether stored payloads differ from the messages
+func (f fileFormat) transformed() bool {
+	return f.compression != CompressionNone || f.encrypted
+}
+
+// encodePayload turns a message into the payload stored in a record
+func (f fileFormat) encodePayload(data []byte, c Cipher) ([]byte, error) {
+	payload, err := f.compression.compress(data)
+	if err != nil || !f.encrypted {
+		return payload, err
+	}
+	if c == nil {
+		return nil, ErrNoCipher
+	}
+	return c.Encrypt(payload)
+}
+
+// decodePayload turns a stored payload back into the message
+func (f fileFormat) decodePayload(payload []byte, maxMsgSize int32, c Cipher) ([]byte, error) {
+	if f.encrypted {
+		if c == nil {
+			return nil, ErrNoCipher
+		}
+		var err error
+		payload, err = c.Decrypt(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f.compression.decompress(payload, maxMsgSize)
+}
+
+// writeRecord frames a payload returned by encodePayload
+func writeRecord(buf *bytes.Buffer, format fileFormat, payload []byte) {
+	binary.Write(buf, binary.BigEndian, int32(len(payload)))
+	if format.version != formatV0 {
+		binary.Write(buf, binary.BigEndian, crc32.Checksum(payload, castagnoliTable))
+	}
+	buf.Write(payload)
+}
+
+// readRecord returns the message of the next record and the number of bytes
+// the record occupies in the file
+func readRecord(r io.Reader, format fileFormat, c Cipher, minMsgSize int32, maxMsgSize int32) ([]byte, int64, error) {
+	var msgSize int32
+	err := binary.Read(r, binary.BigEndian, &msgSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	minSize := minMsgSize
+	if format.transformed() {
+		minSize = 1
+	}
+	if msgSize < minSize || msgSize > format.maxStoredSize(maxMsgSize) {
+		// this file is corrupt and we have no reasonable guarantee on
+		// where a new message should begin
+		return nil, 0, fmt.Errorf("invalid message read size (%d)", msgSize)
+	}
+
+	var checksum uint32
+	if format.version != formatV0 {
+		err = binary.Read(r, binary.BigEndian, &checksum)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	readBuf := make([]byte, msgSize)
+	_, err = io.ReadFull(r, readBuf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if format.version != formatV0 && crc32.Checksum(readBuf, castagnoliTable) != checksum {
+		return nil, 0, fmt.Errorf("%w (message size %d)", ErrChecksumMismatch, msgSize)
+	}
+
+	data, err := format.decodePayload(readBuf, maxMsgSize, c)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int32(len(data)) < minMsgSize {
+		return nil, 0, fmt.Errorf("invalid decompressed message size (%d)", len(data))
+	}
+
+	return data, recordOverhead(format) + int64(msgSize), nil
+}
+
+// Reader sequentially reads the records of a single data file, whatever
+// format or compression it was written in. It is meant for offline tools.
+type Reader struct {
+	r          *bufio.Reader
+	format     fileFormat
+	cipher     Cipher
+	maxMsgSize int32
+	started    bool
+}
+
+func NewReader(r io.Reader, maxMsgSize int32) *Reader {
+	return &Reader{
+		r:          bufio.NewReader(r),
+		maxMsgSize: maxMsgSize,
+	}
+}
+
+// SetCipher sets the Cipher used to decrypt encrypted data files
+func (r *Reader) SetCipher(c Cipher) {
+	r.cipher = c
+}
+
+// Next returns the payload of the next record, or io.EOF when the file ends
+// on a record boundary
+func (r *Reader) Next() ([]byte, error) {
+	if !r.started {
+		r.started = true
+		hdr, err := r.r.Peek(fileHeaderSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		r.format, err = parseFileHeader(hdr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = r.r.Discard(int(fileHeaderLen(r.format))); err != nil {
+			return nil, err
+		}
+	}
+	data, _, err := readRecord(r.r, r.format, r.cipher, 1, r.maxMsgSize)
+	return data, err
+}
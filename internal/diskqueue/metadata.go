@@ -0,0 +1,177 @@
+package diskqueue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metadata file format.
+//
+// The body is the original nsq text layout, "depth\nreadFileNum,readPos\n
+// writeFileNum,writePos\n", so that older versions can still parse it. Since
+// metaDataV1 it is followed by a "v<version> <crc32c of body>\n" trailer. A
+// file without the trailer is accepted as legacy metadata.
+const metaDataV1 = 1
+
+type metaData struct {
+	depth        int64
+	readFileNum  int64
+	readPos      int64
+	writeFileNum int64
+	writePos     int64
+}
+
+func (m metaData) encode() []byte {
+	body := fmt.Sprintf("%d\n%d,%d\n%d,%d\n",
+		m.depth,
+		m.readFileNum, m.readPos,
+		m.writeFileNum, m.writePos)
+	return fmt.Appendf([]byte(body), "v%d %08x\n", metaDataV1, crc32.Checksum([]byte(body), castagnoliTable))
+}
+
+func decodeMetaData(b []byte) (metaData, error) {
+	var m metaData
+
+	// the body is exactly three lines
+	bodyLen := 0
+	for i := 0; i < 3; i++ {
+		n := bytes.IndexByte(b[bodyLen:], '\n')
+		if n < 0 {
+			return m, errors.New("truncated metadata")
+		}
+		bodyLen += n + 1
+	}
+	body, trailer := b[:bodyLen], b[bodyLen:]
+
+	_, err := fmt.Sscanf(string(body), "%d\n%d,%d\n%d,%d\n",
+		&m.depth,
+		&m.readFileNum, &m.readPos,
+		&m.writeFileNum, &m.writePos)
+	if err != nil {
+		return m, err
+	}
+
+	if len(trailer) == 0 {
+		return m, nil
+	}
+
+	var version int
+	var checksum uint32
+	_, err = fmt.Sscanf(string(trailer), "v%d %x\n", &version, &checksum)
+	if err != nil {
+		return m, fmt.Errorf("malformed metadata trailer: %s", err)
+	}
+	if version != metaDataV1 {
+		return m, fmt.Errorf("unsupported metadata version (%d)", version)
+	}
+	if crc32.Checksum(body, castagnoliTable) != checksum {
+		return m, fmt.Errorf("metadata %w", ErrChecksumMismatch)
+	}
+	return m, nil
+}
+
+func loadMetaData(fileName string) (metaData, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return metaData{}, err
+	}
+	return decodeMetaData(b)
+}
+
+// recoverMetaData is used when the metadata file can not be loaded. It falls
+// back to the previous generation, then to reconstructing the positions from
+// the data files left on disk.
+func (d *diskQueue) recoverMetaData(cause error) (metaData, error) {
+	fileName := d.metaDataFileName()
+	prevFileName := d.prevMetaDataFileName()
+
+	m, err := loadMetaData(prevFileName)
+	if err == nil {
+		d.logf(WARN,
+			"DISKQUEUE(%s) %s unusable (%s), recovered previous generation %s: depth=%d read=%d,%d write=%d,%d, messages read after it may be sent again",
+			d.name, fileName, cause, prevFileName,
+			m.depth, m.readFileNum, m.readPos, m.writeFileNum, m.writePos)
+		return m, nil
+	}
+
+	fileNums, err := d.dataFileNums()
+	if err != nil {
+		return m, err
+	}
+	if len(fileNums) == 0 {
+		// nothing to recover, most likely a brand new queue
+		return m, cause
+	}
+
+	m = metaData{
+		readFileNum:  fileNums[0],
+		readPos:      0,
+		writeFileNum: fileNums[len(fileNums)-1] + 1,
+		writePos:     0,
+	}
+	for _, fileNum := range fileNums {
+		m.depth += d.countMessages(d.fileName(fileNum))
+	}
+
+	d.logf(WARN,
+		"DISKQUEUE(%s) %s (%s) and %s unusable, reconstructed from %d data files: reading %d messages from the start of %s, writing to new file %s, messages already sent from these files will be sent again",
+		d.name, fileName, cause, prevFileName, len(fileNums),
+		m.depth, d.fileName(m.readFileNum), d.fileName(m.writeFileNum))
+	return m, nil
+}
+
+// dataFileNums lists the numbers of the data files on disk in ascending order
+func (d *diskQueue) dataFileNums() ([]int64, error) {
+	prefix := d.name + ".diskqueue."
+	matches, err := filepath.Glob(path.Join(d.dataPath, "*.dat"))
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNums []int64
+	for _, match := range matches {
+		base := filepath.Base(match)
+		if !strings.HasPrefix(base, prefix) {
+			continue
+		}
+		fileNum, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(base, prefix), ".dat"), 10, 64)
+		if err != nil {
+			// e.g. the metadata file
+			continue
+		}
+		fileNums = append(fileNums, fileNum)
+	}
+	sort.Slice(fileNums, func(i, j int) bool { return fileNums[i] < fileNums[j] })
+	return fileNums, nil
+}
+
+// countMessages counts the readable messages of a data file
+func (d *diskQueue) countMessages(fileName string) int64 {
+	f, err := os.OpenFile(fileName, os.O_RDONLY, 0600)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var count int64
+	r := NewReader(f, d.maxMsgSize)
+	for {
+		_, err := r.Next()
+		if err != nil {
+			if err != io.EOF {
+				d.logf(WARN, "DISKQUEUE(%s) stopped counting messages of %s after %d - %s", d.name, fileName, count, err)
+			}
+			return count
+		}
+		count++
+	}
+}
@@ -0,0 +1,199 @@
+package diskqueue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// WithRecovery makes the queue resynchronize on the next valid record after a
+// read error instead of discarding the rest of the data file. Only the
+// corrupt bytes are quarantined into a .bad file.
+//
+// Records of checksummed files are validated by their CRC, records of legacy
+// files by validate, which should recognise a well-formed payload (e.g. a
+// JSON object). A non-nil validate is also applied to every record read, so
+// that a corrupt length which happens to be in range is detected as well.
+// With a nil validate legacy files keep the whole-file handling.
+func WithRecovery(validate func(data []byte) bool) Option {
+	return func(d *diskQueue) {
+		d.recovery = true
+		d.validate = validate
+	}
+}
+
+// recoverReadError tries to skip over the corrupt bytes starting at readPos
+// of the current read file. It returns false when the corruption can not be
+// isolated, in which case the caller falls back to handleReadError.
+func (d *diskQueue) recoverReadError() bool {
+	if !d.recovery {
+		return false
+	}
+
+	fn := d.fileName(d.readFileNum)
+	f, err := os.OpenFile(fn, os.O_RDONLY, 0600)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	format, err := readFileFormat(f)
+	if err != nil || (format.version == formatV0 && d.validate == nil) {
+		return false
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	end := stat.Size()
+	if d.readFileNum == d.writeFileNum {
+		if end < d.writePos {
+			// the current write file was truncated behind our back,
+			// appending to it would leave a hole
+			return false
+		}
+		end = d.writePos
+	}
+
+	badPos := d.readPos
+	if badPos < fileHeaderLen(format) || badPos >= end {
+		return false
+	}
+
+	nextPos, found := d.findNextRecord(f, format, badPos+1, end)
+	if !found {
+		nextPos = end
+	}
+
+	badFn := fmt.Sprintf("%s.%d.bad", fn, badPos)
+	err = quarantine(f, badPos, nextPos, badFn)
+	if err != nil {
+		d.logf(ERROR, "DISKQUEUE(%s) failed to save corrupt bytes to %s - %s", d.name, badFn, err)
+	}
+
+	// the skipped records are never read, take them out of depth so that
+	// Depth() is not inflated until the queue drains
+	skipped := d.countRecords(f, format, badPos, nextPos)
+	d.depth = max(d.depth-skipped, 0)
+
+	d.skippedRegions++
+	d.skippedRecords += skipped
+	d.skippedBytes += nextPos - badPos
+	d.logf(WARN,
+		"DISKQUEUE(%s) skipped %d corrupt records (%d bytes) at %d of %s, saved as %s, %d records %d bytes in %d corrupt regions skipped so far",
+		d.name, skipped, nextPos-badPos, badPos, fn, badFn, d.skippedRecords, d.skippedBytes, d.skippedRegions)
+
+	if !found && d.readFileNum < d.writeFileNum {
+		// nothing valid is left in this complete file, move on to the next one
+		if !d.manualAdvance {
+			err := os.Remove(fn)
+			if err != nil {
+				d.logf(ERROR, "DISKQUEUE(%s) failed to Remove(%s) - %s", d.name, fn, err)
+			}
+		}
+		d.readFileNum++
+		nextPos = 0
+	}
+
+	d.readPos = nextPos
+	d.nextReadFileNum = d.readFileNum
+	d.nextReadPos = d.readPos
+
+	// significant state change, schedule a sync on the next iteration
+	d.needSync = true
+
+	d.checkTailCorruption(d.depth)
+	return true
+}
+
+// countRecords counts the records in the corrupt region [from, to) by
+// following their length prefixes. It is exact when only payloads are
+// damaged (the usual checksum mismatch). Once a length is out of range the
+// rest of the region counts as a single record, so the result is then a
+// lower bound, and always at least 1.
+func (d *diskQueue) countRecords(f *os.File, format fileFormat, from int64, to int64) int64 {
+	overhead := recordOverhead(format)
+	maxSize := int64(format.maxStoredSize(d.maxMsgSize))
+	var n int64
+	var sizeBuf [4]byte
+	pos := from
+	for pos < to {
+		if to-pos < overhead {
+			break
+		}
+		if _, err := f.ReadAt(sizeBuf[:], pos); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(sizeBuf[:]))
+		if size < 1 || size > maxSize || pos+overhead+size > to {
+			break
+		}
+		n++
+		pos += overhead + size
+	}
+	if pos < to {
+		n++
+	}
+	return max(n, 1)
+}
+
+// findNextRecord scans [from, end) for the first offset holding a valid record
+func (d *diskQueue) findNextRecord(f *os.File, format fileFormat, from int64, end int64) (int64, bool) {
+	// any record starting in the first half of the window is fully contained in it
+	window := recordOverhead(format) + int64(format.maxStoredSize(d.maxMsgSize))
+	buf := make([]byte, 0, 2*window)
+
+	for base := from; base < end; base += window {
+		n := min(2*window, end-base)
+		buf = buf[:n]
+		_, err := f.ReadAt(buf, base)
+		if err != nil && err != io.EOF {
+			d.logf(ERROR, "DISKQUEUE(%s) failed to scan %s at %d - %s", d.name, f.Name(), base, err)
+			return 0, false
+		}
+
+		for i := int64(0); i < window && i < n; i++ {
+			if d.validRecord(buf[i:], format) {
+				return base + i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (d *diskQueue) validRecord(b []byte, format fileFormat) bool {
+	overhead := recordOverhead(format)
+	if int64(len(b)) < overhead {
+		return false
+	}
+
+	msgSize := int32(binary.BigEndian.Uint32(b))
+	if msgSize < 1 || msgSize > format.maxStoredSize(d.maxMsgSize) || int64(len(b)) < overhead+int64(msgSize) {
+		return false
+	}
+
+	payload := b[overhead : overhead+int64(msgSize)]
+	if format.version != formatV0 && crc32.Checksum(payload, castagnoliTable) != binary.BigEndian.Uint32(b[4:]) {
+		return false
+	}
+	data, err := format.decodePayload(payload, d.maxMsgSize, d.cipher)
+	if err != nil || int32(len(data)) < d.minMsgSize {
+		return false
+	}
+	return d.validate == nil || d.validate(data)
+}
+
+func quarantine(f *os.File, from int64, to int64, badFn string) error {
+	bad, err := os.OpenFile(badFn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(bad, io.NewSectionReader(f, from, to-from))
+	if closeErr := bad.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,364 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+var ErrMalformedEncryptedRecord = errors.New("malformed encrypted record")
+var ErrKeyCallbackNil = errors.New("key provider callbacks can not be nil")
+
+// KeyProvider 提供落盘数据加密使用的 AES 密钥，密钥长度为 16、24 或 32 字节
+//
+// 每条数据都使用 CurrentKey 返回的密钥加密并记录其 ID，解密时通过 Key 查找，
+// 因此轮换密钥后仍需保留旧密钥，直到使用旧密钥写入的数据都已发送或导出
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyProvider 使用内存中的固定密钥，可通过 Rotate 轮换
+type StaticKeyProvider struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+func NewStaticKeyProvider(id string, key []byte) *StaticKeyProvider {
+	p := &StaticKeyProvider{keys: make(map[string][]byte)}
+	p.Rotate(id, key)
+	return p
+}
+
+// Rotate 添加一个新密钥并用于之后的加密，旧密钥仍可用于解密
+func (p *StaticKeyProvider) Rotate(id string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[id] = key
+	p.current = id
+}
+
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *StaticKeyProvider) Key(id string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, id)
+	}
+	return key, nil
+}
+
+// keyFileCheckInterval 密钥文件检查更新的最小间隔
+const keyFileCheckInterval = time.Second
+
+// KeyFileProvider 从密钥文件读取密钥，文件被修改后自动重新加载
+//
+// 文件每行为 "<id> <base64 编码的密钥>"，空行和 # 开头的行会被忽略，
+// 第一个密钥用于加密，其余密钥仅用于解密。轮换时将新密钥添加到第一行即可
+type KeyFileProvider struct {
+	path string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	checkedAt time.Time
+	current   string
+	keys      map[string][]byte
+}
+
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	p := &KeyFileProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload 立即重新加载密钥文件
+func (p *KeyFileProvider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.load()
+}
+
+func (p *KeyFileProvider) load() error {
+	stat, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	current, keys, err := parseKeyFile(b)
+	if err != nil {
+		return fmt.Errorf("key file %s: %w", p.path, err)
+	}
+	p.current, p.keys, p.modTime = current, keys, stat.ModTime()
+	return nil
+}
+
+func (p *KeyFileProvider) refresh() {
+	now := time.Now()
+	if now.Sub(p.checkedAt) < keyFileCheckInterval {
+		return
+	}
+	p.checkedAt = now
+
+	stat, err := os.Stat(p.path)
+	if err != nil || stat.ModTime().Equal(p.modTime) {
+		return
+	}
+	if err := p.load(); err != nil {
+		DefaultLogger.Errorf("Reload key file error, keep using the loaded keys: %s", err)
+	}
+}
+
+func (p *KeyFileProvider) CurrentKey() (string, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *KeyFileProvider) Key(id string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[id]
+	if !ok {
+		// 可能是刚轮换的密钥
+		p.checkedAt = time.Time{}
+		p.refresh()
+		key, ok = p.keys[id]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, id)
+	}
+	return key, nil
+}
+
+func parseKeyFile(b []byte) (string, map[string][]byte, error) {
+	var current string
+	keys := make(map[string][]byte)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return "", nil, fmt.Errorf("line %d: expect \"<id> <base64 key>\"", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return "", nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := checkEncryptionKey(fields[0], key); err != nil {
+			return "", nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if current == "" {
+			current = fields[0]
+		}
+		keys[fields[0]] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	if current == "" {
+		return "", nil, errors.New("no key found")
+	}
+	return current, keys, nil
+}
+
+// CallbackKeyProvider 通过回调获取密钥，适用于从 KMS 等外部系统获取密钥
+type CallbackKeyProvider struct {
+	current func() (string, []byte, error)
+	lookup  func(id string) ([]byte, error)
+}
+
+func NewCallbackKeyProvider(current func() (string, []byte, error), lookup func(id string) ([]byte, error)) (*CallbackKeyProvider, error) {
+	if current == nil || lookup == nil {
+		return nil, ErrKeyCallbackNil
+	}
+	return &CallbackKeyProvider{current: current, lookup: lookup}, nil
+}
+
+// CurrentKey 与 Key 在回调为 nil 时（例如未通过 NewCallbackKeyProvider 创建）返回错误，避免在写入数据时 panic
+func (p *CallbackKeyProvider) CurrentKey() (string, []byte, error) {
+	if p.current == nil {
+		return "", nil, ErrKeyCallbackNil
+	}
+	return p.current()
+}
+
+func (p *CallbackKeyProvider) Key(id string) ([]byte, error) {
+	if p.lookup == nil {
+		return nil, ErrKeyCallbackNil
+	}
+	return p.lookup(id)
+}
+
+func checkEncryptionKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid key size %d for key %q", len(key), id)
+	}
+}
+
+// checkKeyProvider 创建 producer 时检查当前密钥是否可用，避免写入时才发现配置错误
+func checkKeyProvider(p KeyProvider) error {
+	id, key, err := p.CurrentKey()
+	if err != nil {
+		return err
+	}
+	return checkEncryptionKey(id, key)
+}
+
+// 加密记录的格式
+//
+// 0x01 | 密钥 ID 长度 (1 byte) | 密钥 ID | nonce (12 bytes) | AES-GCM 密文
+//
+// 版本和密钥 ID 作为附加数据参与认证
+const encryptedRecordV1 byte = 0x01
+
+// RecordCipher 使用 KeyProvider 提供的密钥加解密单条记录，可并发使用
+type RecordCipher struct {
+	provider KeyProvider
+
+	mu    sync.Mutex
+	aeads map[string]cachedAEAD
+}
+
+type cachedAEAD struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func NewRecordCipher(provider KeyProvider) *RecordCipher {
+	return &RecordCipher{
+		provider: provider,
+		aeads:    make(map[string]cachedAEAD),
+	}
+}
+
+func (c *RecordCipher) aead(id string, key []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.aeads[id]; ok && bytes.Equal(cached.key, key) {
+		return cached.aead, nil
+	}
+
+	if err := checkEncryptionKey(id, key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[id] = cachedAEAD{key: bytes.Clone(key), aead: aead}
+	return aead, nil
+}
+
+func (c *RecordCipher) Encrypt(data []byte) ([]byte, error) {
+	id, key, err := c.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := 2 + len(id)
+	out := make([]byte, headerLen+aead.NonceSize(), headerLen+aead.NonceSize()+len(data)+aead.Overhead())
+	out[0] = encryptedRecordV1
+	out[1] = byte(len(id))
+	copy(out[2:], id)
+	nonce := out[headerLen:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, out[:headerLen]), nil
+}
+
+func (c *RecordCipher) Decrypt(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != encryptedRecordV1 || len(data) < 2+int(data[1]) {
+		return nil, ErrMalformedEncryptedRecord
+	}
+	headerLen := 2 + int(data[1])
+	id := string(data[2:headerLen])
+
+	key, err := c.provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < headerLen+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrMalformedEncryptedRecord
+	}
+	nonce := data[headerLen : headerLen+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[headerLen+aead.NonceSize():], data[:headerLen])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedEncryptedRecord, err)
+	}
+	return plain, nil
+}
+
+// encryptLogLine 加密 persist_only 模式的一行数据，结果为加密记录的 base64 编码
+func encryptLogLine(c *RecordCipher, data []byte) ([]byte, error) {
+	encrypted, err := c.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(encrypted)))
+	base64.StdEncoding.Encode(line, encrypted)
+	return line, nil
+}
+
+// DecodeLogLine 还原 persist_only 模式写入的一行数据，未加密的 JSON 原样返回
+func DecodeLogLine(c *RecordCipher, line []byte) ([]byte, error) {
+	if len(line) > 0 && line[0] == '{' {
+		return line, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("%w: no key provider", ErrUnknownEncryptionKey)
+	}
+	encrypted := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(encrypted, line)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedEncryptedRecord, err)
+	}
+	return c.Decrypt(encrypted[:n])
+}
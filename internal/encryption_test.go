@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordCipher(t *testing.T) {
+	provider := NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	c := NewRecordCipher(provider)
+	data := []byte(`{"#ip":"123.23.11.155"}`)
+
+	encrypted, err := c.Encrypt(data)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(encrypted, data))
+
+	// 轮换后旧数据仍可解密
+	provider.Rotate("k2", bytes.Repeat([]byte{2}, 16))
+	rotated, err := c.Encrypt(data)
+	assert.Nil(t, err)
+	for _, b := range [][]byte{encrypted, rotated} {
+		plain, err := c.Decrypt(b)
+		assert.Nil(t, err)
+		assert.Equal(t, data, plain)
+	}
+
+	// 篡改密文或密钥 ID 都无法解密
+	tampered := bytes.Clone(rotated)
+	tampered[len(tampered)-1] ^= 1
+	_, err = c.Decrypt(tampered)
+	assert.ErrorIs(t, err, ErrMalformedEncryptedRecord)
+	tampered = bytes.Clone(rotated)
+	tampered[3] = '1'
+	_, err = c.Decrypt(tampered)
+	assert.ErrorIs(t, err, ErrMalformedEncryptedRecord)
+
+	_, err = NewRecordCipher(NewStaticKeyProvider("k2", bytes.Repeat([]byte{2}, 16))).Decrypt(encrypted)
+	assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+
+	_, err = NewRecordCipher(NewStaticKeyProvider("bad", []byte("short"))).Encrypt(data)
+	assert.NotNil(t, err)
+}
+
+func TestKeyFileProvider(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("key-file-test-%d", time.Now().UnixNano()))
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	path := filepath.Join(tmpDir, "keys")
+
+	assert.Nil(t, os.WriteFile(path, []byte("# keys\nk1 "+key1+"\n"), 0600))
+	p, err := NewKeyFileProvider(path)
+	assert.Nil(t, err)
+	c := NewRecordCipher(p)
+	encrypted, err := c.Encrypt([]byte("hello"))
+	assert.Nil(t, err)
+
+	// 新密钥添加到第一行
+	assert.Nil(t, os.WriteFile(path, []byte("k2 "+key2+"\nk1 "+key1+"\n"), 0600))
+	assert.Nil(t, p.Reload())
+	id, _, err := p.CurrentKey()
+	assert.Nil(t, err)
+	assert.Equal(t, "k2", id)
+	plain, err := c.Decrypt(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), plain)
+
+	for _, content := range []string{"", "k1\n", "k1 not-base64\n", "k1 " + base64.StdEncoding.EncodeToString([]byte("short")) + "\n"} {
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+		assert.NotNil(t, p.Reload(), content)
+	}
+}
+
+func TestLogLine(t *testing.T) {
+	c := NewRecordCipher(NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32)))
+	data := []byte(`{"data":{"#event":"UserLogin"},"type":"Event"}`)
+
+	line, err := encryptLogLine(c, data)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(line), "\n")
+
+	decoded, err := DecodeLogLine(c, line)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decoded)
+
+	decoded, err = DecodeLogLine(nil, data)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decoded)
+
+	_, err = DecodeLogLine(nil, line)
+	assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+}
+
+func TestCallbackKeyProvider(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	_, err := NewCallbackKeyProvider(nil, func(id string) ([]byte, error) { return key, nil })
+	assert.ErrorIs(t, err, ErrKeyCallbackNil)
+	_, err = NewCallbackKeyProvider(func() (string, []byte, error) { return "k1", key, nil }, nil)
+	assert.ErrorIs(t, err, ErrKeyCallbackNil)
+
+	// 未通过 NewCallbackKeyProvider 创建时返回错误而不是 panic
+	assert.ErrorIs(t, checkKeyProvider(&CallbackKeyProvider{}), ErrKeyCallbackNil)
+	_, err = (&CallbackKeyProvider{}).Key("k1")
+	assert.ErrorIs(t, err, ErrKeyCallbackNil)
+
+	p, err := NewCallbackKeyProvider(func() (string, []byte, error) { return "k1", key, nil }, func(id string) ([]byte, error) { return key, nil })
+	assert.Nil(t, err)
+	assert.Nil(t, checkKeyProvider(p))
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 选择 ingest 地址的策略
+const (
+	EndpointStrategyFailover   = "failover"    // 按顺序使用第一个健康的地址，前面的地址恢复后切换回去
+	EndpointStrategyRoundRobin = "round_robin" // 轮流使用健康的地址
+	EndpointStrategyLatency    = "latency"     // 使用平均延迟最低的健康地址
+)
+
+var ErrEndpointStrategyIllegal = errors.New("unknown endpoint strategy")
+
+const (
+	endpointCooldownMin = 5 * time.Second  // 地址第一次失败后不再使用的时长
+	endpointCooldownMax = 60 * time.Second // 连续失败时不再使用的最长时长
+	latencyWeight       = 0.2              // 平均延迟中最新一次请求的权重
+)
+
+func checkEndpointStrategy(strategy string) error {
+	switch strategy {
+	case "", EndpointStrategyFailover, EndpointStrategyRoundRobin, EndpointStrategyLatency:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrEndpointStrategyIllegal, strategy)
+	}
+}
+
+type endpoint struct {
+	url            string
+	failures       int           // 连续失败次数
+	unhealthyUntil time.Time     // 在此之前不使用该地址
+	latency        time.Duration // 成功请求的平均延迟，0 表示还没有数据
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return !now.Before(e.unhealthyUntil)
+}
+
+// endpointPool 根据发送结果（以及可选的主动探测）维护各地址的健康状态，并按策略选择地址
+type endpointPool struct {
+	mu        sync.Mutex
+	strategy  string
+	endpoints []*endpoint
+	next      int // round_robin 下一次开始查找的位置
+}
+
+func newEndpointPool(urls []string, strategy string) *endpointPool {
+	if strategy == "" {
+		strategy = EndpointStrategyFailover
+	}
+	p := &endpointPool{strategy: strategy}
+	for _, url := range urls {
+		p.endpoints = append(p.endpoints, &endpoint{url: url})
+	}
+	return p
+}
+
+// pick 选择一个地址，所有地址都不健康时使用最早恢复的地址
+func (p *endpointPool) pick() *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var picked *endpoint
+	switch p.strategy {
+	case EndpointStrategyRoundRobin:
+		for i := range p.endpoints {
+			e := p.endpoints[(p.next+i)%len(p.endpoints)]
+			if e.healthy(now) {
+				picked = e
+				p.next = (p.next + i + 1) % len(p.endpoints)
+				break
+			}
+		}
+	case EndpointStrategyLatency:
+		for _, e := range p.endpoints {
+			if e.healthy(now) && (picked == nil || e.latency < picked.latency) {
+				picked = e
+			}
+		}
+	default:
+		for _, e := range p.endpoints {
+			if e.healthy(now) {
+				picked = e
+				break
+			}
+		}
+	}
+	if picked != nil {
+		return picked
+	}
+
+	for _, e := range p.endpoints {
+		if picked == nil || e.unhealthyUntil.Before(picked.unhealthyUntil) {
+			picked = e
+		}
+	}
+	return picked
+}
+
+// report 记录一次请求的结果，失败的地址在冷却时间内不再使用，冷却时间随连续失败次数增加
+func (p *endpointPool) report(e *endpoint, latency time.Duration, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !ok {
+		e.failures++
+		cooldown := min(endpointCooldownMin<<min(e.failures-1, 4), endpointCooldownMax)
+		e.unhealthyUntil = time.Now().Add(cooldown)
+		DefaultLogger.Warnf("ingest endpoint %s unhealthy for %s after %d failures", e.url, cooldown, e.failures)
+		return
+	}
+
+	if e.failures > 0 {
+		DefaultLogger.Infof("ingest endpoint %s recovered", e.url)
+	}
+	e.failures = 0
+	e.unhealthyUntil = time.Time{}
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(e.latency))
+	}
+}
+
+// probe 并发地主动探测所有地址，每个地址最多等待 timeout，能收到 5xx 以外的响应即认为地址可用
+func (p *endpointPool) probe(timeout time.Duration, httpClient *http.Client) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.probeOne(e, timeout, httpClient)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *endpointPool) probeOne(e *endpoint, timeout time.Duration, httpClient *http.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	ok := err == nil && resp.StatusCode < http.StatusInternalServerError
+
+	// 探测失败只影响已经恢复使用的地址，避免冷却时间被不断延长
+	p.mu.Lock()
+	skip := !ok && !e.healthy(time.Now())
+	p.mu.Unlock()
+	if !skip {
+		p.report(e, time.Since(start), ok)
+	}
+}
+
+// probeLoop 定时主动探测，直到 stop 被关闭
+func (p *endpointPool) probeLoop(interval, timeout time.Duration, httpClient *http.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.probe(timeout, httpClient)
+		}
+	}
+}
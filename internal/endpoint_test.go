@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+)
+
+func pickURLs(p *endpointPool, n int) []string {
+	var urls []string
+	for i := 0; i < n; i++ {
+		urls = append(urls, p.pick().url)
+	}
+	return urls
+}
+
+func TestEndpointPool(t *testing.T) {
+	urls := []string{"a", "b", "c"}
+
+	p := newEndpointPool(urls, "")
+	assert.Equal(t, []string{"a", "a"}, pickURLs(p, 2))
+	p.report(p.endpoints[0], 0, false)
+	assert.Equal(t, []string{"b", "b"}, pickURLs(p, 2))
+	// 冷却时间随连续失败次数增加
+	assert.WithinDuration(t, time.Now().Add(endpointCooldownMin), p.endpoints[0].unhealthyUntil, time.Second)
+	p.report(p.endpoints[0], 0, false)
+	assert.WithinDuration(t, time.Now().Add(2*endpointCooldownMin), p.endpoints[0].unhealthyUntil, time.Second)
+	// 恢复后切换回来
+	p.report(p.endpoints[0], time.Millisecond, true)
+	assert.Equal(t, "a", p.pick().url)
+
+	// 所有地址都不可用时使用最早恢复的地址
+	for i := 2; i >= 0; i-- {
+		p.report(p.endpoints[i], 0, false)
+	}
+	assert.Equal(t, "c", p.pick().url)
+
+	p = newEndpointPool(urls, EndpointStrategyRoundRobin)
+	assert.Equal(t, []string{"a", "b", "c", "a"}, pickURLs(p, 4))
+	p.report(p.endpoints[1], 0, false)
+	assert.Equal(t, []string{"c", "a", "c"}, pickURLs(p, 3))
+
+	p = newEndpointPool(urls, EndpointStrategyLatency)
+	p.report(p.endpoints[0], 30*time.Millisecond, true)
+	p.report(p.endpoints[1], 10*time.Millisecond, true)
+	p.report(p.endpoints[2], 20*time.Millisecond, true)
+	assert.Equal(t, "b", p.pick().url)
+	// 平均延迟逐渐变化
+	p.report(p.endpoints[1], 60*time.Millisecond, true)
+	assert.Equal(t, 20*time.Millisecond, p.endpoints[1].latency)
+	assert.Equal(t, "b", p.pick().url)
+	p.report(p.endpoints[1], 60*time.Millisecond, true)
+	assert.Equal(t, "c", p.pick().url)
+	p.report(p.endpoints[2], 0, false)
+	assert.Equal(t, "b", p.pick().url)
+
+	assert.ErrorIs(t, checkEndpointStrategy("random"), ErrEndpointStrategyIllegal)
+}
+
+// 测试主地址不可用时切换到备用地址
+func TestIngestSenderFailover(t *testing.T) {
+	defer gock.Off()
+
+	s, err := newIngestSender(ingestSenderConfig{
+		Endpoints: []string{"http://primary.ingest.com", "http://secondary.ingest.com", "http://primary.ingest.com"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, s.endpoints.endpoints, 2)
+
+	CreateGockReq("http://primary.ingest.com", "/v1/collect").Reply(503).BodyString("unavailable")
+	CreateGockReq("http://secondary.ingest.com", "/v1/collect").Times(2).Reply(200).JSON(map[string]interface{}{"error": nil})
+
+	assert.Nil(t, s.Collect(context.Background(), newTestMessages(1)))
+	// 主地址冷却期间直接使用备用地址
+	assert.Nil(t, s.Collect(context.Background(), newTestMessages(1)))
+	assert.True(t, gock.IsDone())
+
+	// 鉴权失败说明地址可以正常响应
+	CreateGockReq("http://secondary.ingest.com", "/v1/collect").Reply(401).JSON(map[string]interface{}{"error": "unauthorized"})
+	assert.NotNil(t, s.Collect(context.Background(), newTestMessages(1)))
+	assert.Equal(t, 0, s.endpoints.endpoints[1].failures)
+}
+
+// 测试主动探测恢复地址
+func TestIngestSenderProbe(t *testing.T) {
+	defer gock.Off()
+
+	s, err := newIngestSender(ingestSenderConfig{
+		Endpoints:     []string{"http://primary.ingest.com", "http://secondary.ingest.com"},
+		ProbeInterval: 50 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	s.endpoints.report(s.endpoints.endpoints[0], 0, false)
+	assert.Equal(t, "http://secondary.ingest.com", s.endpoints.pick().url)
+
+	gock.New("http://primary.ingest.com").Get("/").Persist().Reply(http.StatusNotFound)
+	gock.New("http://secondary.ingest.com").Get("/").Persist().Reply(http.StatusOK)
+	s.Start()
+	defer s.Close()
+
+	assert.Eventually(t, func() bool {
+		return s.endpoints.pick().url == "http://primary.ingest.com"
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+// 测试响应慢的地址不会占用其他地址的探测时间
+func TestEndpointPoolProbeTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer fast.Close()
+
+	p := newEndpointPool([]string{slow.URL, fast.URL, fast.URL + "/b"}, "")
+	p.report(p.endpoints[1], 0, false)
+	p.report(p.endpoints[2], 0, false)
+
+	start := time.Now()
+	p.probe(200*time.Millisecond, &http.Client{Transport: &http.Transport{}})
+	assert.Less(t, time.Since(start), time.Second)
+
+	now := time.Now()
+	assert.False(t, p.endpoints[0].healthy(now))
+	assert.True(t, p.endpoints[1].healthy(now))
+	assert.True(t, p.endpoints[2].healthy(now))
+}
@@ -0,0 +1,399 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	RequestCompressionNone = "none"
+	RequestCompressionGzip = "gzip"
+	RequestCompressionZstd = "zstd" // 需要 ingest 服务端支持，服务端确认支持前不建议开启
+)
+
+var ErrRequestCompressionIllegal = errors.New("unknown request compression")
+
+// ErrRequestTooLarge 请求体超过 MaxRequestSize，或者 ingest 返回 413 等请求过大的错误
+var ErrRequestTooLarge = errors.New("request too large")
+
+const (
+	requestOverhead = len(`{"batchId":"","messages":[]}`)
+	messageOverhead = len(`{"type":"","data":},`)
+)
+
+const (
+	collectApi             = "/v1/collect"
+	retryTimeIntervalInit  = 100 * time.Millisecond
+	retryTimeIntervalMax   = 5 * time.Second
+	ingestSenderUserAgent  = "funnydb-" + SdkType + "/" + SdkVersion
+	closeIdleConnsInterval = 20
+)
+
+var requestEncoding = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type ingestSenderConfig struct {
+	Endpoints       []string
+	Strategy        string        // 多个地址时的选择策略，默认 failover
+	ProbeInterval   time.Duration // 主动探测各地址的间隔，0 表示只根据发送结果判断
+	ProbeTimeout    time.Duration
+	AccessKey       string
+	AccessSecret    string
+	Compression     string // none, gzip, zstd，默认 gzip
+	CompressMinSize int    // 请求体小于该字节数时不压缩
+	MaxRequestSize  int    // 压缩前的请求体超过该字节数时不发送，直接返回 ErrRequestTooLarge，0 表示不限制
+	HTTP            HTTPConfig
+
+	Timeout    time.Duration   // 每次 Collect（包括重试）的超时时间，不包括限速等待的时间，0 表示只由 ctx 控制
+	Limiter    *rateLimiter    // 每次请求（包括重试与拆分后的请求）前按请求体压缩前的大小等待，nil 表示不限速
+	Unthrottle <-chan struct{} // 关闭后不再等待限速，producer 关闭时尽快发送剩余的数据
+}
+
+// ingestSender 发送数据到 ingest 的 /v1/collect 接口
+//
+// 请求格式、签名与重试策略与 ingest-client-go-sdk 的 client.Client 一致，User-Agent 为 SDK 的版本，
+// 另外支持 zstd 压缩（需要服务端支持），以及较小的请求不压缩。返回的错误同样是 client.Error
+//
+// 配置多个地址时每次请求（包括重试）按策略选择健康的地址
+type ingestSender struct {
+	config     ingestSenderConfig
+	httpClient *http.Client
+	endpoints  *endpointPool
+	reqCount   int64
+	stopProbe  chan struct{}
+	closeOnce  sync.Once
+}
+
+func checkRequestCompression(compression string) error {
+	switch compression {
+	case "", RequestCompressionNone, RequestCompressionGzip, RequestCompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrRequestCompressionIllegal, compression)
+	}
+}
+
+func newIngestSender(config ingestSenderConfig) (*ingestSender, error) {
+	var urls []string
+	for _, url := range config.Endpoints {
+		if url != "" && !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if err := checkRequestCompression(config.Compression); err != nil {
+		return nil, err
+	}
+	if err := checkEndpointStrategy(config.Strategy); err != nil {
+		return nil, err
+	}
+	if config.Compression == "" {
+		config.Compression = RequestCompressionGzip
+	}
+	if config.Compression == RequestCompressionZstd {
+		DefaultLogger.Warnf("request compression zstd requires ingest server support, requests will be rejected by servers that only accept gzip")
+	}
+	httpClient, err := newHTTPClient(config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ingestSender{
+		config:     config,
+		httpClient: httpClient,
+		endpoints:  newEndpointPool(urls, config.Strategy),
+		stopProbe:  make(chan struct{}),
+	}, nil
+}
+
+// Start 开启主动探测，需要调用 Close 停止
+func (s *ingestSender) Start() {
+	if s.config.ProbeInterval <= 0 {
+		return
+	}
+	timeout := s.config.ProbeTimeout
+	if timeout <= 0 || timeout > s.config.ProbeInterval {
+		timeout = s.config.ProbeInterval
+	}
+	go s.endpoints.probeLoop(s.config.ProbeInterval, timeout, s.httpClient, s.stopProbe)
+}
+
+// Close 停止主动探测
+func (s *ingestSender) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stopProbe)
+	})
+}
+
+// Collect 发送一批数据，可重试的错误在 ctx 结束或者超过 Timeout 前会一直重试
+func (s *ingestSender) Collect(ctx context.Context, messages *client.Messages) error {
+	data, err := requestEncoding.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	// 压缩前的大小不超过限制时，压缩后的请求体同样不会超过
+	if s.config.MaxRequestSize > 0 && len(data) > s.config.MaxRequestSize {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrRequestTooLarge, len(data), s.config.MaxRequestSize)
+	}
+
+	size := len(data)
+	contentEncoding := ""
+	if s.config.Compression != RequestCompressionNone && len(data) >= s.config.CompressMinSize {
+		data, err = compressRequestBody(s.config.Compression, data)
+		if err != nil {
+			return err
+		}
+		contentEncoding = s.config.Compression
+	}
+
+	var deadline time.Time
+	if s.config.Timeout > 0 {
+		deadline = time.Now().Add(s.config.Timeout)
+	}
+	timeInterval := retryTimeIntervalInit
+	for {
+		err := s.doRequest(ctx, &deadline, data, size, contentEncoding)
+		if err == nil {
+			return nil
+		}
+		if !shouldRetry(err) {
+			return err
+		}
+
+		timeInterval = min(timeInterval*2, retryTimeIntervalMax)
+		DefaultLogger.Warnf("failed to send request: %s, retry after %s", err, timeInterval)
+		wait := timeInterval
+		if !deadline.IsZero() {
+			wait = min(wait, time.Until(deadline))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+}
+
+// doRequest 发送一次请求，发送前等待限速，等待的时间顺延 deadline
+func (s *ingestSender) doRequest(ctx context.Context, deadline *time.Time, data []byte, size int, contentEncoding string) error {
+	if s.config.Limiter != nil {
+		start := time.Now()
+		s.config.Limiter.wait(size, s.config.Unthrottle)
+		if !deadline.IsZero() {
+			*deadline = deadline.Add(time.Since(start))
+		}
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, *deadline)
+		defer cancel()
+	}
+
+	e := s.endpoints.pick()
+	start := time.Now()
+	err := s.doRequestTo(ctx, e.url, data, contentEncoding)
+	// 请求被取消不代表地址有问题，4xx 等不可重试的错误说明地址可以正常响应
+	if ctx.Err() == nil {
+		s.endpoints.report(e, time.Since(start), err == nil || !isEndpointFailure(err))
+	}
+	return err
+}
+
+// isEndpointFailure 判断错误是否说明地址不可用
+func isEndpointFailure(err error) bool {
+	var ingestErr client.Error
+	if errors.As(err, &ingestErr) {
+		return ingestErr.StatusCode >= 500
+	}
+	return true
+}
+
+func (s *ingestSender) doRequestTo(ctx context.Context, endpoint string, data []byte, contentEncoding string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+collectApi, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	// 与 ingest-client-go-sdk 相同，定期断开连接使请求均匀分布到各 ingest server，
+	// 调用方提供的连接池由调用方管理，不断开
+	if !s.config.HTTP.callerPool() && atomic.AddInt64(&s.reqCount, 1)%closeIdleConnsInterval == 0 {
+		req.Close = true
+		s.httpClient.CloseIdleConnections()
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ingest-Client-ID", "")
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if s.config.AccessKey != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := strconv.Itoa(rand.Int())
+		req.Header.Set("X-AccessKeyId", s.config.AccessKey)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Nonce", nonce)
+		signature := calculateSignature(http.MethodPost, collectApi, s.config.AccessKey, timestamp, nonce, s.config.AccessSecret, data)
+		req.Header.Set("X-Signature", base64.StdEncoding.EncodeToString(signature))
+	}
+	req.Header.Set("User-Agent", ingestSenderUserAgent)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		rerr := client.Error{}
+		if err := json.Unmarshal(body, &rerr); err != nil {
+			rerr.Message = string(body)
+		}
+		rerr.StatusCode = resp.StatusCode
+		rerr.Status = resp.Status
+		if isPayloadTooLarge(rerr) {
+			return fmt.Errorf("%w: %w", ErrRequestTooLarge, rerr)
+		}
+		return rerr
+	}
+	return nil
+}
+
+// isPayloadTooLarge 判断 ingest 是否因为请求体超过 body_limit_byte 拒绝了请求
+func isPayloadTooLarge(err client.Error) bool {
+	if err.StatusCode == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	if err.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	msg := strings.ToLower(err.Message)
+	return strings.Contains(msg, "too large") || strings.Contains(msg, "body limit")
+}
+
+// CollectSplitting 发送一批数据，请求过大时拆成两半分别发送，直到只剩一条数据，
+// 单条数据仍然过大时交给 oversized 处理后跳过。
+// 返回错误时 messages 只保留还未发送的数据，重试时不会重复发送已经成功的部分
+func (s *ingestSender) CollectSplitting(ctx context.Context, messages *client.Messages, oversized func(msg client.Message, err error)) error {
+	sent, err := s.collectSplitting(ctx, messages.Messages, oversized)
+	messages.Messages = messages.Messages[sent:]
+	return err
+}
+
+func (s *ingestSender) collectSplitting(ctx context.Context, msgs []client.Message, oversized func(msg client.Message, err error)) (int, error) {
+	err := s.Collect(ctx, &client.Messages{Messages: msgs})
+	if err == nil {
+		return len(msgs), nil
+	}
+	if !errors.Is(err, ErrRequestTooLarge) {
+		return 0, err
+	}
+	if len(msgs) == 1 {
+		oversized(msgs[0], err)
+		return 1, nil
+	}
+
+	half := len(msgs) / 2
+	DefaultLogger.Warnf("request of %d messages too large, split into %d and %d: %s", len(msgs), half, len(msgs)-half, err)
+	sent, err := s.collectSplitting(ctx, msgs[:half], oversized)
+	if err != nil {
+		return sent, err
+	}
+	sent, err = s.collectSplitting(ctx, msgs[half:], oversized)
+	return half + sent, err
+}
+
+// queueRecordRequestSize 返回磁盘队列记录发送时在请求体中占用的字节数（不小于实际大小）
+func queueRecordRequestSize(record []byte) int {
+	return len(record) + messageOverhead
+}
+
+func calculateSignature(method, api, accessKeyId, timestamp, nonce, accessKeySecret string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(accessKeySecret))
+	h.Write([]byte(method))
+	h.Write([]byte(api))
+	h.Write([]byte(accessKeyId))
+	h.Write([]byte(nonce))
+	h.Write([]byte(timestamp))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// shouldRetry 与 ingest-client-go-sdk 的重试条件一致
+func shouldRetry(err error) bool {
+	var ingestErr client.Error
+	if errors.As(err, &ingestErr) {
+		code := ingestErr.StatusCode
+		return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || (code >= 500 && code <= 599)
+	}
+	if errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound || dnsErr.IsTemporary
+	}
+	return false
+}
+
+var (
+	gzipWriterPool = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+)
+
+func compressRequestBody(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case RequestCompressionGzip:
+		var buf bytes.Buffer
+		zw := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(zw)
+		zw.Reset(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case RequestCompressionZstd:
+		zstdOnce.Do(func() {
+			// 选项固定，不会返回错误
+			zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		})
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrRequestCompressionIllegal, compression)
+	}
+}
@@ -0,0 +1,329 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"github.com/h2non/gock"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMessages(n int) *client.Messages {
+	msgs := &client.Messages{}
+	for i := 0; i < n; i++ {
+		msgs.Messages = append(msgs.Messages, client.Message{
+			Type: EventTypeValue,
+			Data: json.RawMessage(`{"#event":"UserLogin","level":1}`),
+		})
+	}
+	return msgs
+}
+
+// decodeRequestBody 按 Content-Encoding 解压请求体，请求体可以被其他 mock 再次读取
+func decodeRequestBody(req *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	switch req.Header.Get("Content-Encoding") {
+	case "":
+		return body, nil
+	case RequestCompressionGzip:
+		return GunzipData(body)
+	case RequestCompressionZstd:
+		d, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return d.DecodeAll(body, nil)
+	default:
+		return nil, errors.New("unexpected Content-Encoding")
+	}
+}
+
+func TestIngestSenderCompression(t *testing.T) {
+	defer gock.Off()
+
+	tests := []struct {
+		compression     string
+		minSize         int
+		contentEncoding string
+	}{
+		{"", 0, RequestCompressionGzip},
+		{RequestCompressionGzip, 0, RequestCompressionGzip},
+		{RequestCompressionZstd, 0, RequestCompressionZstd},
+		{RequestCompressionNone, 0, ""},
+		{RequestCompressionZstd, 1024, ""},
+	}
+	for _, tt := range tests {
+		s, err := newIngestSender(ingestSenderConfig{
+			Endpoints:       []string{"http://ingest.com"},
+			AccessKey:       "demo",
+			AccessSecret:    "demo",
+			Compression:     tt.compression,
+			CompressMinSize: tt.minSize,
+		})
+		assert.Nil(t, err)
+
+		matcher := gock.NewBasicMatcher()
+		matcher.Add(func(req *http.Request, _ *gock.Request) (bool, error) {
+			if req.Header.Get("Content-Encoding") != tt.contentEncoding || req.Header.Get("X-Signature") == "" ||
+				req.Header.Get("User-Agent") != "funnydb-go-sdk/"+SdkVersion {
+				return false, nil
+			}
+			data, err := decodeRequestBody(req)
+			if err != nil {
+				return false, err
+			}
+			var batch client.Messages
+			if err := json.Unmarshal(data, &batch); err != nil {
+				return false, err
+			}
+			return len(batch.Messages) == 2, nil
+		})
+		CreateGockReq("http://ingest.com", "/v1/collect").
+			SetMatcher(matcher).
+			Reply(200).
+			JSON(map[string]interface{}{"error": nil})
+
+		err = s.Collect(context.Background(), newTestMessages(2))
+		assert.Nil(t, err, tt.compression)
+		assert.True(t, gock.IsDone(), tt.compression)
+	}
+
+	_, err := newIngestSender(ingestSenderConfig{Endpoints: []string{"http://ingest.com"}, Compression: "br"})
+	assert.ErrorIs(t, err, ErrRequestCompressionIllegal)
+}
+
+func TestIngestSenderRetry(t *testing.T) {
+	defer gock.Off()
+
+	s, err := newIngestSender(ingestSenderConfig{Endpoints: []string{"http://ingest.com"}})
+	assert.Nil(t, err)
+
+	// 服务端错误重试后成功
+	CreateGockReq("http://ingest.com", "/v1/collect").Reply(503).BodyString("unavailable")
+	CreateGockReq("http://ingest.com", "/v1/collect").Reply(200).JSON(map[string]interface{}{"error": nil})
+	assert.Nil(t, s.Collect(context.Background(), newTestMessages(1)))
+	assert.True(t, gock.IsDone())
+
+	// 鉴权失败不重试
+	CreateGockReq("http://ingest.com", "/v1/collect").Reply(401).JSON(map[string]interface{}{"error": "unauthorized"})
+	err = s.Collect(context.Background(), newTestMessages(1))
+	var ingestErr client.Error
+	assert.ErrorAs(t, err, &ingestErr)
+	assert.Equal(t, 401, ingestErr.StatusCode)
+	assert.Equal(t, "unauthorized", ingestErr.Message)
+
+	// 一直失败时在 ctx 结束后返回
+	CreateGockReq("http://ingest.com", "/v1/collect").Persist().Reply(500).BodyString("error")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Collect(ctx, newTestMessages(1)), context.DeadlineExceeded)
+}
+
+// 测试重试条件与签名和 ingest-client-go-sdk 保持一致，升级依赖后不一致时失败
+func TestIngestSenderMatchesUpstream(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature := calculateSignature(http.MethodPost, collectApi, r.Header.Get("X-AccessKeyId"),
+			r.Header.Get("X-Timestamp"), r.Header.Get("X-Nonce"), "secret", body)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(signature), r.Header.Get("X-Signature"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"error"}`))
+			return
+		}
+		writeIngestResponse(w)
+	}))
+	defer srv.Close()
+
+	upstream, err := client.NewClient(client.Config{
+		Endpoint:                 srv.URL,
+		AccessKeyID:              "demo",
+		AccessKeySecret:          "secret",
+		RetryTimeIntervalInitial: time.Millisecond,
+	})
+	assert.Nil(t, err)
+	s, err := newIngestSender(ingestSenderConfig{Endpoints: []string{srv.URL}, AccessKey: "demo", AccessSecret: "secret"})
+	assert.Nil(t, err)
+
+	// 返回值为请求的次数，2 表示重试了一次
+	collect := func(code int, fn func() error) int {
+		mu.Lock()
+		requests, status = 0, code
+		mu.Unlock()
+		fn()
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+	for code := 400; code < 600; code++ {
+		if http.StatusText(code) == "" {
+			continue
+		}
+		want := collect(code, func() error { return upstream.Collect(context.Background(), newTestMessages(1)) })
+		got := collect(code, func() error { return s.Collect(context.Background(), newTestMessages(1)) })
+		assert.Equal(t, want, got, "status %d", code)
+	}
+
+	// 连接被拒绝
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	refused := "http://" + l.Addr().String()
+	l.Close()
+	upstream, err = client.NewClient(client.Config{Endpoint: refused, RetryTimeIntervalInitial: time.Millisecond})
+	assert.Nil(t, err)
+	s, err = newIngestSender(ingestSenderConfig{Endpoints: []string{refused}})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, upstream.Collect(ctx, newTestMessages(1)), context.DeadlineExceeded)
+	assert.ErrorIs(t, s.Collect(ctx, newTestMessages(1)), context.DeadlineExceeded)
+}
+
+// 测试重试的每次请求都计入限速，超时时间不包括限速等待的时间
+func TestIngestSenderRetryRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		if len(times) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeIngestResponse(w)
+	}))
+	defer srv.Close()
+
+	limiter := newRateLimiter(RateLimit{RequestsPerSecond: 1})
+	s, err := newIngestSender(ingestSenderConfig{
+		Endpoints: []string{srv.URL},
+		Timeout:   1500 * time.Millisecond, // 加上限速等待的时间共需要约 2s
+		Limiter:   limiter,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Collect(context.Background(), newTestMessages(1)))
+
+	// 没有限速时重试的间隔为 200ms 与 400ms
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, times, 3)
+	for i := 1; i < len(times); i++ {
+		assert.GreaterOrEqual(t, times[i].Sub(times[i-1]), 900*time.Millisecond)
+	}
+	assert.Equal(t, int64(2), limiter.throttleStatus().ThrottledTimes)
+}
+
+// batchSizeMatcher 按请求中的数据条数匹配
+func batchSizeMatcher(match func(n int) bool) *gock.MockMatcher {
+	matcher := gock.NewBasicMatcher()
+	matcher.Add(func(req *http.Request, _ *gock.Request) (bool, error) {
+		data, err := decodeRequestBody(req)
+		if err != nil {
+			return false, err
+		}
+		var batch client.Messages
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return false, err
+		}
+		return match(len(batch.Messages)), nil
+	})
+	return matcher
+}
+
+func TestIngestSenderSplitting(t *testing.T) {
+	defer gock.Off()
+
+	s, err := newIngestSender(ingestSenderConfig{Endpoints: []string{"http://ingest.com"}, MaxRequestSize: 1024})
+	assert.Nil(t, err)
+
+	// ingest 最多接受两条数据
+	var accepted int
+	CreateGockReq("http://ingest.com", "/v1/collect").
+		Persist().
+		SetMatcher(batchSizeMatcher(func(n int) bool { return n > 2 })).
+		Reply(413).
+		BodyString("body limit exceeded")
+	CreateGockReq("http://ingest.com", "/v1/collect").
+		Persist().
+		SetMatcher(batchSizeMatcher(func(n int) bool { accepted += n; return true })).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+
+	var oversized []client.Message
+	onOversized := func(msg client.Message, err error) {
+		assert.ErrorIs(t, err, ErrRequestTooLarge)
+		oversized = append(oversized, msg)
+	}
+
+	msgs := newTestMessages(5)
+	assert.Nil(t, s.CollectSplitting(context.Background(), msgs, onOversized))
+	assert.Equal(t, 5, accepted)
+	assert.Empty(t, oversized)
+
+	// 单条数据超过 MaxRequestSize 时不会发送
+	accepted = 0
+	msgs = newTestMessages(3)
+	msgs.Messages[1].Data = json.RawMessage(`{"#event":"UserLogin","payload":"` + strings.Repeat("x", 2048) + `"}`)
+	large := msgs.Messages[1]
+	assert.Nil(t, s.CollectSplitting(context.Background(), msgs, onOversized))
+	assert.Empty(t, msgs.Messages)
+	assert.Equal(t, 2, accepted)
+	assert.Len(t, oversized, 1)
+	assert.Equal(t, large, oversized[0])
+}
+
+// 测试拆分后部分发送失败时只保留未发送的数据
+func TestIngestSenderSplittingPartialFailure(t *testing.T) {
+	defer gock.Off()
+
+	s, err := newIngestSender(ingestSenderConfig{Endpoints: []string{"http://ingest.com"}})
+	assert.Nil(t, err)
+
+	CreateGockReq("http://ingest.com", "/v1/collect").
+		SetMatcher(batchSizeMatcher(func(n int) bool { return n == 4 })).
+		Reply(413).
+		BodyString("request entity too large")
+	CreateGockReq("http://ingest.com", "/v1/collect").
+		SetMatcher(batchSizeMatcher(func(n int) bool { return n == 2 })).
+		Reply(200).
+		JSON(map[string]interface{}{"error": nil})
+	CreateGockReq("http://ingest.com", "/v1/collect").
+		Reply(401).
+		JSON(map[string]interface{}{"error": "unauthorized"})
+
+	msgs := newTestMessages(4)
+	err = s.CollectSplitting(context.Background(), msgs, func(client.Message, error) {
+		t.Fatal("unexpected oversized message")
+	})
+	var ingestErr client.Error
+	assert.ErrorAs(t, err, &ingestErr)
+	assert.Equal(t, 401, ingestErr.StatusCode)
+	assert.Len(t, msgs.Messages, 2)
+	assert.True(t, gock.IsDone())
+}
@@ -0,0 +1,65 @@
+package internal
+
+import "context"
+
+// Lane 数据发送的优先级通道，开启优先级通道后每个通道使用独立的队列和缓冲区
+type Lane int
+
+const (
+	LaneDefault Lane = iota // 按数据类型决定: Mutation 为 LaneHigh，SDK 统计数据为 LaneLow，其余为 LaneNormal
+	LaneHigh
+	LaneNormal
+	LaneLow
+)
+
+// lanes 按优先级从高到低排列
+var lanes = []Lane{LaneHigh, LaneNormal, LaneLow}
+
+// DefaultLaneWeights 各通道都有积压时，每一轮发送的批次数
+var DefaultLaneWeights = map[Lane]int{
+	LaneHigh:   6,
+	LaneNormal: 3,
+	LaneLow:    1,
+}
+
+// statsEventName 与 funnydb.StatCollector 上报的事件名一致
+const statsEventName = "#sdk_send_stats"
+
+func (l Lane) String() string {
+	switch l {
+	case LaneDefault:
+		return "default"
+	case LaneHigh:
+		return "high"
+	case LaneNormal:
+		return "normal"
+	case LaneLow:
+		return "low"
+	default:
+		return "unknown"
+	}
+}
+
+type laneContextKey struct{}
+
+// ContextWithLane 指定通过 ctx 写入 producer 的数据使用的通道
+func ContextWithLane(ctx context.Context, lane Lane) context.Context {
+	if lane == LaneDefault {
+		return ctx
+	}
+	return context.WithValue(ctx, laneContextKey{}, lane)
+}
+
+// laneOf 返回数据使用的通道，ctx 未指定时按数据类型决定
+func laneOf(ctx context.Context, data map[string]interface{}) Lane {
+	if lane, ok := ctx.Value(laneContextKey{}).(Lane); ok && lane >= LaneHigh && lane <= LaneLow {
+		return lane
+	}
+	if data["type"] != EventTypeValue {
+		return LaneHigh
+	}
+	if d, ok := data["data"].(map[string]interface{}); ok && d[DataFieldNameEvent] == statsEventName {
+		return LaneLow
+	}
+	return LaneNormal
+}
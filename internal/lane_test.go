@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal/diskqueue"
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaneOf(t *testing.T) {
+	event := map[string]interface{}{
+		"type": EventTypeValue,
+		"data": map[string]interface{}{DataFieldNameEvent: "UserLogin"},
+	}
+	stats := map[string]interface{}{
+		"type": EventTypeValue,
+		"data": map[string]interface{}{DataFieldNameEvent: statsEventName},
+	}
+	mutation := map[string]interface{}{
+		"type": "UserMutation",
+		"data": map[string]interface{}{},
+	}
+
+	ctx := context.Background()
+	assert.Equal(t, LaneNormal, laneOf(ctx, event))
+	assert.Equal(t, LaneLow, laneOf(ctx, stats))
+	assert.Equal(t, LaneHigh, laneOf(ctx, mutation))
+
+	assert.Equal(t, LaneHigh, laneOf(ContextWithLane(ctx, LaneHigh), event))
+	assert.Equal(t, LaneLow, laneOf(ContextWithLane(ctx, LaneLow), mutation))
+	assert.Equal(t, LaneNormal, laneOf(ContextWithLane(ctx, LaneDefault), event))
+}
+
+// newLaneQueues 创建各通道的磁盘队列，每个通道写入 n 条内容为通道名的数据
+func newLaneQueues(t *testing.T, n int) *AsyncProducer {
+	dir := t.TempDir()
+	p := &AsyncProducer{byLane: make(map[Lane]*asyncLane)}
+	for _, lane := range lanes {
+		q := diskqueue.New(laneQueueName(lane), dir, 1024*1024, 1, 1024, 1<<62, time.Second, true, NewAppLogFunc())
+		t.Cleanup(func() { q.Close() })
+		batch := make([][]byte, n)
+		for i := range batch {
+			batch[i] = []byte(lane.String())
+		}
+		assert.NoError(t, q.PutBatch(batch))
+
+		l := &asyncLane{lane: lane, q: q, weight: DefaultLaneWeights[lane], credit: DefaultLaneWeights[lane]}
+		l.reset()
+		p.lanes = append(p.lanes, l)
+		p.byLane[lane] = l
+		p.laneChans = append(p.laneChans, q.ReadChan())
+	}
+	return p
+}
+
+// readWeighted 读取 nextLane 选出的通道，每条数据单独作为一批发送
+func readWeighted(t *testing.T, p *AsyncProducer) (*asyncLane, bool) {
+	l := p.nextLane()
+	if l == nil {
+		return nil, false
+	}
+	msg := <-l.q.ReadChan()
+	assert.Equal(t, l.lane.String(), string(msg))
+	l.credit--
+	l.q.Advance()
+	l.reset()
+	return l, true
+}
+
+// 测试各通道都有积压时按权重读取
+func TestNextLane(t *testing.T) {
+	p := newLaneQueues(t, 100)
+
+	var order []string
+	for i := 0; i < 20; i++ {
+		l, ok := readWeighted(t, p)
+		assert.True(t, ok)
+		order = append(order, l.lane.String()[:1])
+	}
+	assert.Equal(t, "hhhhhhnnnlhhhhhhnnnl", strings.Join(order, ""))
+
+	// 高优先级通道没有数据时不会阻塞其他通道
+	high := p.byLane[LaneHigh]
+	for high.pending() > 0 {
+		<-high.q.ReadChan()
+		high.unacked++
+	}
+	l, ok := readWeighted(t, p)
+	assert.True(t, ok)
+	assert.NotEqual(t, LaneHigh, l.lane)
+}
+
+// 测试磁盘队列都有积压时按权重发送，不受队列读取时机的影响
+func TestNextLaneDrainRatio(t *testing.T) {
+	p := newLaneQueues(t, 300)
+
+	counts := make(map[Lane]int)
+	for i := 0; i < 900; i++ {
+		l, ok := readWeighted(t, p)
+		if !assert.True(t, ok) {
+			return
+		}
+		counts[l.lane]++
+		if i == 499 {
+			// 50 轮中各通道发送的批次数与权重成正比
+			assert.Equal(t, map[Lane]int{LaneHigh: 300, LaneNormal: 150, LaneLow: 50}, counts)
+		}
+	}
+	assert.Equal(t, map[Lane]int{LaneHigh: 300, LaneNormal: 300, LaneLow: 300}, counts)
+
+	_, ok := readWeighted(t, p)
+	assert.False(t, ok)
+}
+
+// staleDepthQueue 队列深度不为 0 但读取不到数据，例如尾部损坏只在读取时发现
+type staleDepthQueue struct {
+	diskqueue.Interface
+}
+
+func (q *staleDepthQueue) Depth() int64            { return 5 }
+func (q *staleDepthQueue) ReadChan() <-chan []byte { return nil }
+func (q *staleDepthQueue) Advance()                {}
+func (q *staleDepthQueue) Close() error            { return nil }
+
+// 测试按权重选出的通道读取不到数据时，其他通道的数据仍然按时发送
+func TestAsyncSenderStaleDepth(t *testing.T) {
+	received := make(chan int, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := decodeRequestBody(r)
+		var msgs client.Messages
+		json.Unmarshal(body, &msgs)
+		received <- len(msgs.Messages)
+		writeIngestResponse(w)
+	}))
+	defer srv.Close()
+
+	ingestClient, err := newIngestSender(ingestSenderConfig{Endpoints: []string{srv.URL}, Timeout: 5 * time.Second})
+	assert.Nil(t, err)
+	p := newLaneQueues(t, 0)
+	p.byLane[LaneHigh].q = &staleDepthQueue{}
+	p.laneChans[0] = nil
+	for i := 0; i < 3; i++ {
+		record, err := encodeQueueRecord(map[string]interface{}{
+			"type": EventTypeValue,
+			"data": map[string]interface{}{DataFieldNameEvent: "UserLogin"},
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, p.byLane[LaneNormal].q.Put(record))
+	}
+	p.status = running
+	p.config = &AsyncProducerConfig{SendInterval: 100 * time.Millisecond, BatchSize: 1 << 20, MaxBufferRecords: 100}
+	p.closeCh = make(chan struct{})
+	p.ingestClient = ingestClient
+	p.scheduler = NewSendScheduler()
+	defer p.scheduler.Close()
+	p.scheduler.add(p)
+
+	select {
+	case n := <-received:
+		assert.Equal(t, 3, n)
+	case <-time.After(3 * time.Second):
+		t.Error("buffered records are not sent")
+	}
+	close(p.closeCh)
+	p.scheduler.remove(p)
+}
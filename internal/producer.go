@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var ErrProducerClosed = errors.New("producer has been closed")
@@ -23,8 +24,51 @@ const (
 	DataFieldNameIp         = "#ip"
 	DataFieldNameProperties = "properties"
 	DataFieldNameHostname   = "#hostname"
+
+	// 可以在属性中设置的预置字段
+	DataFieldNameAccountId    = "#account_id"
+	DataFieldNameDeviceId     = "#device_id"
+	DataFieldNameChannel      = "#channel"
+	DataFieldNameOsPlatform   = "#os_platform"
+	DataFieldNameManufacturer = "#manufacturer"
+	DataFieldNameDeviceModel  = "#device_model"
+	DataFieldNameScreenWidth  = "#screen_width"
+	DataFieldNameScreenHeight = "#screen_height"
+	DataFieldNameCpuModel     = "#cpu_model"
+	DataFieldNameCpuCoreCount = "#cpu_core_count"
+	DataFieldNameCpuFrequency = "#cpu_frequency"
+	DataFieldNameRamCapacity  = "#ram_capacity"
 )
 
+// SdkDataFields 由 SDK 填充的事件字段，#log_id 未设置时才会填充
+var SdkDataFields = map[string]bool{
+	DataFieldNameSdkType:    true,
+	DataFieldNameSdkVersion: true,
+	DataFieldNameHostname:   true,
+	DataFieldNameEvent:      true,
+	DataFieldNameTime:       true,
+	DataFieldNameLogId:      true,
+	DataFieldNameOperate:    true,
+	DataFieldNameIdentify:   true,
+}
+
+// PresetDataFields 可以在属性中设置的预置字段
+var PresetDataFields = map[string]bool{
+	DataFieldNameIp:           true,
+	DataFieldNameAccountId:    true,
+	DataFieldNameDeviceId:     true,
+	DataFieldNameChannel:      true,
+	DataFieldNameOsPlatform:   true,
+	DataFieldNameManufacturer: true,
+	DataFieldNameDeviceModel:  true,
+	DataFieldNameScreenWidth:  true,
+	DataFieldNameScreenHeight: true,
+	DataFieldNameCpuModel:     true,
+	DataFieldNameCpuCoreCount: true,
+	DataFieldNameCpuFrequency: true,
+	DataFieldNameRamCapacity:  true,
+}
+
 const (
 	running int32 = 1
 	stop    int32 = 0
@@ -34,3 +78,53 @@ type Producer interface {
 	Add(ctx context.Context, data map[string]interface{}) error
 	Close(ctx context.Context) error
 }
+
+// BatchProducer 支持一次写入多条数据的 Producer
+type BatchProducer interface {
+	AddBatch(ctx context.Context, data []map[string]interface{}) error
+}
+
+// PausableProducer 支持暂停发送的 Producer，暂停期间写入的数据会保存到恢复发送
+type PausableProducer interface {
+	PauseSending() bool
+	ResumeSending() bool
+	SendingStatus() SendingStatus
+}
+
+// SendingStatus 发送状态
+type SendingStatus struct {
+	Paused   bool
+	PausedAt time.Time // 暂停的时间，未暂停时为零值
+	Pending  int64     // 已写入但还未确认发送成功的数据条数
+}
+
+// 数据未发送而被丢弃的原因
+const (
+	DiscardReasonExpired  = "expired"   // 超过 MaxRecordAge
+	DiscardReasonTooLarge = "too_large" // 单条数据的请求就超过 BatchSize 或被 ingest 以请求过大拒绝
+)
+
+// DiscardedRecord 一条未发送而被丢弃的数据
+type DiscardedRecord struct {
+	Reason string
+	Type   string    // Event 或 Mutation 的类型
+	Name   string    // 事件名，Mutation 为空
+	Time   time.Time // 数据的 #time
+	Data   []byte    // 数据 JSON
+}
+
+// DiscardFunc 在 producer 丢弃数据时调用，调用期间会阻塞发送
+type DiscardFunc func(record DiscardedRecord)
+
+func newDiscardedRecord(reason string, msgType string, data []byte, msgTime int64) DiscardedRecord {
+	r := DiscardedRecord{
+		Reason: reason,
+		Type:   msgType,
+		Time:   time.UnixMilli(msgTime),
+		Data:   data,
+	}
+	if msgType == EventTypeValue {
+		r.Name = numberEncoding.Get(data, DataFieldNameEvent).ToString()
+	}
+	return r
+}
@@ -2,14 +2,18 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/funny/funnydb-go-sdk/v2/internal/diskqueue"
 	client "github.com/funny/ingest-client-go-sdk/v2"
-	"golang.org/x/sync/errgroup"
 )
 
 type AsyncProducerConfig struct {
@@ -22,24 +26,104 @@ type AsyncProducerConfig struct {
 	SendInterval     time.Duration
 	SendTimeout      time.Duration
 	BatchSize        int64
+	LockTimeout      time.Duration
+	QueueChecksum    bool
+	QueueCompression string
+	KeyProvider      KeyProvider
+	MaxRecordAge     time.Duration
+	OnDiscard        DiscardFunc
+	PriorityLanes    bool
+	LaneWeights      map[Lane]int
+	RateLimit        RateLimit
+
+	RequestCompression     string
+	RequestCompressMinSize int
+
+	IngestEndpoints       []string
+	EndpointStrategy      string
+	EndpointProbeInterval time.Duration
+
+	HTTP HTTPConfig
+
+	Scheduler *SendScheduler // 共用的发送调度，nil 时单独创建
 }
 
 type AsyncProducer struct {
 	status       int32
 	config       *AsyncProducerConfig
-	q            diskqueue.Interface
-	eg           *errgroup.Group
-	egCtx        context.Context
-	closeCh      chan interface{}
-	ingestClient *client.Client
+	lanes        []*asyncLane // 按优先级从高到低排列
+	byLane       map[Lane]*asyncLane
+	laneChans    []<-chan []byte // 各通道的队列读取 channel，与 lanes 的顺序一致
+	dirLock      *DirLock
+	scheduler    *SendScheduler
+	ownScheduler bool // scheduler 由该 producer 创建，关闭时一起关闭
+	closeCh      chan struct{}
+	ingestClient *ingestSender
+	deadLetter   *deadLetter
+	limiter      *rateLimiter
 	existErr     error
+
+	pauseMu  sync.Mutex
+	pausedAt time.Time
+	resumeCh chan struct{} // 暂停期间不为 nil，恢复发送时关闭
+}
+
+// asyncLane 一个通道的磁盘队列，以及调度协程中该通道待发送的数据
+type asyncLane struct {
+	lane   Lane
+	q      diskqueue.Interface
+	weight int
+	credit int // 本轮剩余可发送的批次数
+
+	lastCommitedAt time.Time
+	msgs           [][]byte
+	msgSize        int
+	unacked        int64 // 已从队列读取但还没有 Advance 的数据条数
+}
+
+// reset 开始新的一批，在 Advance 之后调用
+func (l *asyncLane) reset() {
+	l.lastCommitedAt = time.Now()
+	l.msgs = [][]byte{}
+	l.msgSize = 0
+	l.unacked = 0
+}
+
+// laneQueueName 返回通道的磁盘队列名，LaneNormal 沿用开启优先级通道之前的队列
+func laneQueueName(lane Lane) string {
+	if lane == LaneNormal {
+		return "funnydb"
+	}
+	return "funnydb-" + lane.String()
 }
 
 func NewAsyncProducer(config AsyncProducerConfig) (Producer, error) {
-	ingestClient, err := client.NewClient(client.Config{
-		Endpoint:        config.IngestEndpoint,
-		AccessKeyID:     config.AccessKey,
-		AccessKeySecret: config.AccessSecret,
+	compression, err := diskqueue.ParseCompression(config.QueueCompression)
+	if err != nil {
+		return nil, err
+	}
+	if config.KeyProvider != nil {
+		if err := checkKeyProvider(config.KeyProvider); err != nil {
+			return nil, err
+		}
+	}
+
+	limiter := newRateLimiter(config.RateLimit)
+	closeCh := make(chan struct{})
+	ingestClient, err := newIngestSender(ingestSenderConfig{
+		Endpoints:       append([]string{config.IngestEndpoint}, config.IngestEndpoints...),
+		Strategy:        config.EndpointStrategy,
+		ProbeInterval:   config.EndpointProbeInterval,
+		ProbeTimeout:    config.SendTimeout,
+		AccessKey:       config.AccessKey,
+		AccessSecret:    config.AccessSecret,
+		Compression:     config.RequestCompression,
+		CompressMinSize: config.RequestCompressMinSize,
+		MaxRequestSize:  int(config.BatchSize),
+		HTTP:            config.HTTP,
+		Timeout:         config.SendTimeout,
+		Limiter:         limiter,
+		Unthrottle:      closeCh,
 	})
 	if err != nil {
 		return nil, err
@@ -55,179 +139,434 @@ func NewAsyncProducer(config AsyncProducerConfig) (Producer, error) {
 		}
 	}
 
-	dq := diskqueue.New(
-		"funnydb",
-		config.Directory,
-		128*1024*1024, // 128MB
-		1,
-		20*1024*1024,         // 20MB
-		1<<62,                // do not fsync at every n-th message
-		500*time.Millisecond, // fsync every 500ms
-		true,
-		NewAppLogFunc(),
-	)
+	dirLock, err := LockDirectory(config.Directory, config.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
 
-	eg, ctx := errgroup.WithContext(context.Background())
+	dqOpts := []diskqueue.Option{diskqueue.WithRecovery(isQueueRecord)}
+	if config.QueueChecksum {
+		dqOpts = append(dqOpts, diskqueue.WithChecksum())
+	}
+	dqOpts = append(dqOpts, diskqueue.WithCompression(compression))
+	var cipher *RecordCipher
+	if config.KeyProvider != nil {
+		cipher = NewRecordCipher(config.KeyProvider)
+		dqOpts = append(dqOpts, diskqueue.WithEncryption(cipher))
+	}
 
 	p := AsyncProducer{
 		status:       running,
 		config:       &config,
-		q:            dq,
-		eg:           eg,
-		egCtx:        ctx,
-		closeCh:      make(chan interface{}),
+		byLane:       make(map[Lane]*asyncLane),
+		dirLock:      dirLock,
+		scheduler:    config.Scheduler,
+		closeCh:      closeCh,
 		ingestClient: ingestClient,
+		deadLetter:   newDeadLetter(config.Directory, cipher),
+		limiter:      limiter,
 		existErr:     ErrProducerClosed,
 	}
+
+	for _, lane := range lanes {
+		name := laneQueueName(lane)
+		if !config.PriorityLanes && lane != LaneNormal {
+			// 关闭优先级通道后，仍然发送之前写入其他通道的数据
+			_, err := os.Stat(filepath.Join(config.Directory, name+".diskqueue.meta.dat"))
+			if err != nil {
+				continue
+			}
+		}
+
+		weight := config.LaneWeights[lane]
+		if weight <= 0 {
+			weight = DefaultLaneWeights[lane]
+		}
+
+		l := &asyncLane{
+			lane:   lane,
+			weight: weight,
+			credit: weight,
+			q: diskqueue.New(
+				name,
+				config.Directory,
+				128*1024*1024, // 128MB
+				1,
+				20*1024*1024,         // 20MB
+				1<<62,                // do not fsync at every n-th message
+				500*time.Millisecond, // fsync every 500ms
+				true,
+				NewAppLogFunc(),
+				dqOpts...,
+			),
+		}
+		l.reset()
+		p.lanes = append(p.lanes, l)
+		p.byLane[lane] = l
+		p.laneChans = append(p.laneChans, l.q.ReadChan())
+	}
+	if p.scheduler == nil {
+		p.scheduler = NewSendScheduler()
+		p.ownScheduler = true
+	}
+
 	return &p, p.init()
 }
 
+// laneFor 返回数据写入的通道，未开启优先级通道时都写入 LaneNormal
+func (p *AsyncProducer) laneFor(ctx context.Context, data map[string]interface{}) *asyncLane {
+	if !p.config.PriorityLanes {
+		return p.byLane[LaneNormal]
+	}
+	return p.byLane[laneOf(ctx, data)]
+}
+
 func (p *AsyncProducer) Add(ctx context.Context, data map[string]interface{}) error {
 	var err error = nil
 
 	if atomic.LoadInt32(&p.status) == stop {
 		err = p.existErr
 	} else {
-		jsonData, jsonErr := marshalToBytes(data)
-		if jsonErr != nil {
-			err = jsonErr
+		record, encodeErr := encodeQueueRecord(data)
+		if encodeErr != nil {
+			err = encodeErr
 		} else {
-			err = p.q.Put(jsonData)
+			err = p.laneFor(ctx, data).q.Put(record)
 		}
 	}
 
 	return err
 }
 
+// AddBatch 批量写入磁盘队列，每个通道只与队列进行一次交互
+func (p *AsyncProducer) AddBatch(ctx context.Context, data []map[string]interface{}) error {
+	if atomic.LoadInt32(&p.status) == stop {
+		return p.existErr
+	}
+
+	batches := make(map[*asyncLane][][]byte)
+	for _, d := range data {
+		record, err := encodeQueueRecord(d)
+		if err != nil {
+			return err
+		}
+		l := p.laneFor(ctx, d)
+		batches[l] = append(batches[l], record)
+	}
+	for _, l := range p.lanes {
+		if batch, ok := batches[l]; ok {
+			if err := l.q.PutBatch(batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (p *AsyncProducer) Close(ctx context.Context) error {
 	if atomic.CompareAndSwapInt32(&p.status, running, stop) {
 		close(p.closeCh)
-		p.eg.Wait()
-		if err := p.q.Close(); err != nil {
-			DefaultLogger.Errorf("Close diskQ error : %s", err)
+		// 等待正在进行的发送结束，未发送的数据保留在磁盘队列中
+		p.scheduler.remove(p)
+		if p.ownScheduler {
+			p.scheduler.Close()
 		}
+		p.closeQueue()
 		return nil
 	} else {
 		return p.existErr
 	}
 }
 
+func (p *AsyncProducer) closeQueue() {
+	p.ingestClient.Close()
+	for _, l := range p.lanes {
+		if err := l.q.Close(); err != nil {
+			DefaultLogger.Errorf("Close diskQ %s error : %s", laneQueueName(l.lane), err)
+		}
+	}
+	if err := p.dirLock.Unlock(); err != nil {
+		DefaultLogger.Errorf("Unlock directory error : %s", err)
+	}
+}
+
 func (p *AsyncProducer) init() error {
 
-	p.eg.Go(p.runSender)
+	p.ingestClient.Start()
+	p.scheduler.add(p)
 
 	DefaultLogger.Infof("ModeAsync staring, log path: %s", p.config.Directory)
 
 	return nil
 }
 
-func (p *AsyncProducer) runSender() error {
-	ingestSendIntervalTicker := time.NewTicker(p.config.SendInterval)
+// isExpired 检查数据的 #time 是否超过 MaxRecordAge，超过时通过 OnDiscard 上报
+func (p *AsyncProducer) isExpired(msgType string, msgData []byte) bool {
+	if p.config.MaxRecordAge <= 0 {
+		return false
+	}
+	msgTime := numberEncoding.Get(msgData, DataFieldNameTime).ToInt64()
+	if msgTime <= 0 || time.Since(time.UnixMilli(msgTime)) <= p.config.MaxRecordAge {
+		return false
+	}
+	if p.config.OnDiscard != nil {
+		p.config.OnDiscard(newDiscardedRecord(DiscardReasonExpired, msgType, msgData, msgTime))
+	}
+	return true
+}
 
-	var minBackoff = time.Duration(200+rand.Int63n(100)) * time.Millisecond
-	var maxBackoff = 60 * time.Second
+// onOversized 单条就超过请求大小限制的数据写入 dead-letter 文件后跳过
+func (p *AsyncProducer) onOversized(msg client.Message, err error) {
+	data, _ := msg.Data.(json.RawMessage)
+	DefaultLogger.Errorf("move %s message of %d bytes to %s: %s", msg.Type, len(data), DeadLetterFileName, err)
+	if werr := p.deadLetter.write(msg.Type, data); werr != nil {
+		DefaultLogger.Errorf("write dead letter error: %s", werr)
+	}
+	if p.config.OnDiscard != nil {
+		msgTime := numberEncoding.Get(data, DataFieldNameTime).ToInt64()
+		p.config.OnDiscard(newDiscardedRecord(DiscardReasonTooLarge, msg.Type, data, msgTime))
+	}
+}
 
-	var (
-		lastCommitedAt time.Time
-		msgs           [][]byte
-		msgSize        int
-	)
+// PauseSending 暂停发送，返回 false 表示已经处于暂停状态
+func (p *AsyncProducer) PauseSending() bool {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	if p.resumeCh != nil {
+		return false
+	}
+	p.resumeCh = make(chan struct{})
+	p.pausedAt = time.Now()
+	p.scheduler.wakeup()
+	DefaultLogger.Info("Sender paused")
+	return true
+}
 
-	reset := func() {
-		lastCommitedAt = time.Now()
-		msgs = [][]byte{}
-		msgSize = 0
+// ResumeSending 恢复发送，返回 false 表示没有处于暂停状态
+func (p *AsyncProducer) ResumeSending() bool {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	if p.resumeCh == nil {
+		return false
 	}
-	reset()
+	close(p.resumeCh)
+	p.resumeCh = nil
+	p.scheduler.wakeup()
+	DefaultLogger.Infof("Sender resumed after %s", time.Since(p.pausedAt))
+	p.pausedAt = time.Time{}
+	return true
+}
 
-	send := func() {
-		clientMsgs := &client.Messages{}
-		var msg client.Message
-		for _, bytesMsg := range msgs {
-			err := numberEncoding.Unmarshal(bytesMsg, &msg)
-			if err != nil {
-				DefaultLogger.Errorf("unmarshal message error when send data : %s", err)
-				continue
-			}
-			clientMsgs.Messages = append(clientMsgs.Messages, msg)
-		}
+func (p *AsyncProducer) SendingStatus() SendingStatus {
+	p.pauseMu.Lock()
+	status := SendingStatus{
+		Paused:   p.resumeCh != nil,
+		PausedAt: p.pausedAt,
+	}
+	p.pauseMu.Unlock()
 
-		var restTime = minBackoff
+	for _, l := range p.lanes {
+		status.Pending += l.q.Depth()
+	}
+	return status
+}
 
-	lp:
-		for {
-			select {
-			case <-p.closeCh:
-				DefaultLogger.Info("Collect loop receive close sig, exit")
-				return
-			case <-p.egCtx.Done():
-				DefaultLogger.Info("Collect loop error sig, exit")
-				return
-			default:
-				ctx, cancel := context.WithTimeout(context.Background(), p.config.SendTimeout)
-
-				err := p.ingestClient.Collect(ctx, clientMsgs)
-				cancel()
-				if err != nil {
-					DefaultLogger.Errorf("send data failed : %s", err)
-					DefaultLogger.Warnf("will retry after %s", restTime)
-					time.Sleep(restTime)
-					restTime = restTime * 2
-					if restTime > maxBackoff {
-						restTime = maxBackoff
-					}
-					continue lp
-				}
-				break lp
-			}
+func (p *AsyncProducer) SetRateLimit(limit RateLimit) {
+	p.limiter.setLimit(limit)
+}
 
-		}
+func (p *AsyncProducer) ThrottleStatus() ThrottleStatus {
+	return p.limiter.throttleStatus()
+}
 
-		p.q.Advance()
-		reset()
+// waitResume 暂停期间阻塞直到恢复发送，producer 关闭时返回 false
+func (p *AsyncProducer) waitResume() bool {
+	p.pauseMu.Lock()
+	resumeCh := p.resumeCh
+	p.pauseMu.Unlock()
+	if resumeCh == nil {
+		return true
 	}
 
-	AppendAndCheckProcess := func(msgBytes []byte) {
-		if msgBytes != nil {
-			if int64(msgSize+len(msgBytes)) > p.config.BatchSize {
-				send()
-			}
+	select {
+	case <-p.closeCh:
+		return false
+	case <-resumeCh:
+		return true
+	}
+}
 
-			msgs = append(msgs, msgBytes)
-			msgSize += len(msgBytes)
+func (p *AsyncProducer) paused() bool {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	return p.resumeCh != nil
+}
+
+// pending 返回通道的队列中还没有读取的数据条数，手动 Advance 的队列深度包括已读取但未确认的数据。
+// 队列深度只用于选择通道，尾部损坏等情况下可能不准确
+func (l *asyncLane) pending() int64 {
+	return l.q.Depth() - l.unacked
+}
 
-			if len(msgs) >= p.config.MaxBufferRecords {
-				send()
+// nextLane 按权重返回下一个读取的通道，所有通道都没有数据时返回 nil
+//
+// 按优先级顺序优先选择本轮还有发送额度的通道，额度用完的通道只在其他通道都没有数据时选择，
+// 并开始新的一轮。因此各通道都有积压时，每轮发送的批次数与权重成正比。
+// 通道是否有数据按队列深度判断：ReadChan 没有缓冲，队列每读出一条后要读取下一条才能再次就绪，
+// 非阻塞地尝试读取会把有积压的通道当作没有数据
+func (p *AsyncProducer) nextLane() *asyncLane {
+	for _, l := range p.lanes {
+		if l.credit > 0 && l.pending() > 0 {
+			return l
+		}
+	}
+	for _, l := range p.lanes {
+		if l.credit <= 0 && l.pending() > 0 {
+			for _, l := range p.lanes {
+				l.credit = l.weight
 			}
+			return l
 		}
 	}
+	return nil
+}
+
+func (p *AsyncProducer) sendInterval() time.Duration {
+	return p.config.SendInterval
+}
+
+// readChans 返回各通道的队列读取 channel，暂停期间数据保留在磁盘队列中，不读取到内存
+func (p *AsyncProducer) readChans() []<-chan []byte {
+	if p.paused() {
+		return nil
+	}
+	return p.laneChans
+}
 
-	defer func() {
-		ingestSendIntervalTicker.Stop()
+func (p *AsyncProducer) preferredLane() int {
+	if p.paused() {
+		return -1
+	}
+	if l := p.nextLane(); l != nil {
+		return slices.Index(p.lanes, l)
+	}
+	return -1
+}
 
-		if atomic.CompareAndSwapInt32(&p.status, running, stop) {
-			close(p.closeCh)
-			if err := p.q.Close(); err != nil {
-				DefaultLogger.Errorf("Close diskQ error : %s", err)
+// receive 缓冲从通道读取的数据，批次达到 BatchSize 或者 MaxBufferRecords 时返回发送任务
+func (p *AsyncProducer) receive(lane int, record []byte) func() {
+	l := p.lanes[lane]
+	// 先计数再发送：send 中的 Advance 同样会确认这一条
+	l.unacked++
+	// 按请求体的大小而不是记录的大小计算，保证请求不超过 BatchSize
+	size := queueRecordRequestSize(record)
+	if len(l.msgs) > 0 && int64(requestOverhead+l.msgSize+size) > p.config.BatchSize {
+		return func() {
+			p.send(l)
+			if l.append(record, size, p.config.MaxBufferRecords) {
+				p.send(l)
 			}
 		}
-	}()
+	}
+	if l.append(record, size, p.config.MaxBufferRecords) {
+		return func() { p.send(l) }
+	}
+	return nil
+}
+
+// append 缓冲一条数据，返回是否达到 maxRecords
+func (l *asyncLane) append(record []byte, size int, maxRecords int) bool {
+	l.msgs = append(l.msgs, record)
+	l.msgSize += size
+	return len(l.msgs) >= maxRecords
+}
+
+// due 检测是否太久没有发送数据，返回发送任务，高优先级通道先发送
+func (p *AsyncProducer) due() func() {
+	if p.paused() {
+		return nil
+	}
+	var due []*asyncLane
+	for _, l := range p.lanes {
+		if time.Since(l.lastCommitedAt) >= p.config.SendInterval && len(l.msgs) > 0 {
+			due = append(due, l)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	return func() {
+		for _, l := range due {
+			p.send(l)
+		}
+	}
+}
 
+// send 发送通道缓冲的数据，失败时一直重试直到成功或者 producer 关闭，成功后确认队列中已读取的数据
+func (p *AsyncProducer) send(l *asyncLane) {
+	var minBackoff = time.Duration(200+rand.Int63n(100)) * time.Millisecond
+	var maxBackoff = 60 * time.Second
+
+	clientMsgs := &client.Messages{}
+	expired := 0
+	for _, bytesMsg := range l.msgs {
+		msgType, msgData, err := DecodeQueueRecord(bytesMsg)
+		if err == nil && !numberEncoding.Valid(msgData) {
+			err = fmt.Errorf("%w: invalid data json", ErrMalformedQueueRecord)
+		}
+		if err != nil {
+			DefaultLogger.Errorf("decode message error when send data : %s", err)
+			continue
+		}
+		if p.isExpired(msgType, msgData) {
+			expired++
+			continue
+		}
+		clientMsgs.Messages = append(clientMsgs.Messages, client.Message{
+			Type: msgType,
+			Data: json.RawMessage(msgData),
+		})
+	}
+	if expired > 0 {
+		DefaultLogger.Warnf("Discard %d messages older than %s", expired, p.config.MaxRecordAge)
+	}
+	l.credit--
+	if len(clientMsgs.Messages) == 0 {
+		l.q.Advance()
+		l.reset()
+		return
+	}
+
+	restTime := minBackoff
+lp:
 	for {
 		select {
 		case <-p.closeCh:
-			DefaultLogger.Info("Sender receive close sig, exist")
-			return nil
-		case <-p.egCtx.Done():
-			DefaultLogger.Info("Sender receive error sig, exist")
-			return nil
-		case <-ingestSendIntervalTicker.C:
-			// 以下流程检测是否太久没有发送数据
-			if time.Since(lastCommitedAt) >= p.config.SendInterval && len(msgs) > 0 {
-				send()
+			DefaultLogger.Info("Collect loop receive close sig, exit")
+			return
+		default:
+			// 暂停期间（包括重试之间）不再请求 ingest
+			if !p.waitResume() {
+				DefaultLogger.Info("Collect loop receive close sig while paused, exit")
+				return
 			}
-		case line := <-p.q.ReadChan():
-			AppendAndCheckProcess(line)
+			// ingestSender 的每次请求（包括重试与拆分后的请求）都计入限速，超时时间不包括限速等待的时间。
+			// 失败时 clientMsgs 只保留未发送的数据
+			err := p.ingestClient.CollectSplitting(context.Background(), clientMsgs, p.onOversized)
+			if err != nil {
+				DefaultLogger.Errorf("send data failed : %s", err)
+				DefaultLogger.Warnf("will retry after %s", restTime)
+				time.Sleep(restTime)
+				restTime = restTime * 2
+				if restTime > maxBackoff {
+					restTime = maxBackoff
+				}
+				continue lp
+			}
+			break lp
 		}
 	}
+
+	l.q.Advance()
+	l.reset()
 }
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"slices"
 	"sync/atomic"
 	"time"
 
@@ -17,24 +18,55 @@ type IngestProducerConfig struct {
 	MaxBufferRecords int
 	SendInterval     time.Duration
 	SendTimeout      time.Duration
+	BatchSize        int64
+	OnDiscard        DiscardFunc
+	PriorityLanes    bool
+	RateLimit        RateLimit
+
+	RequestCompression     string
+	RequestCompressMinSize int
+
+	IngestEndpoints       []string
+	EndpointStrategy      string
+	EndpointProbeInterval time.Duration
+
+	HTTP HTTPConfig
+
+	Scheduler *SendScheduler // 共用的发送调度，nil 时单独创建
 }
 
 type IngestProducer struct {
 	status       int32
 	config       *IngestProducerConfig
-	ingestClient *client.Client
-	buffer       []*client.Message
-	sendTimer    *time.Timer
-	reportChan   chan *client.Message
+	ingestClient *ingestSender
+	limiter      *rateLimiter
+	scheduler    *SendScheduler
+	ownScheduler bool            // scheduler 由该 producer 创建，关闭时一起关闭
+	reportChans  []chan []byte   // 各通道等待调度协程读取的数据，与 lanes 的顺序一致
+	laneChans    []<-chan []byte // reportChans 的只读形式
+	buffers      [][][]byte      // 各通道缓冲的数据，只在调度协程或者发送任务中访问
+	lastSentAt   time.Time
 	loopDie      chan struct{}
-	loopExited   chan struct{}
 }
 
 func NewIngestProducer(config IngestProducerConfig) (Producer, error) {
-	ingestClient, err := client.NewClient(client.Config{
-		Endpoint:        config.IngestEndpoint,
-		AccessKeyID:     config.AccessKey,
-		AccessKeySecret: config.AccessSecret,
+	limiter := newRateLimiter(config.RateLimit)
+	loopDie := make(chan struct{})
+	ingestClient, err := newIngestSender(ingestSenderConfig{
+		Endpoints:       append([]string{config.IngestEndpoint}, config.IngestEndpoints...),
+		Strategy:        config.EndpointStrategy,
+		ProbeInterval:   config.EndpointProbeInterval,
+		ProbeTimeout:    config.SendTimeout,
+		AccessKey:       config.AccessKey,
+		AccessSecret:    config.AccessSecret,
+		Compression:     config.RequestCompression,
+		CompressMinSize: config.RequestCompressMinSize,
+		MaxRequestSize:  int(config.BatchSize),
+		HTTP:            config.HTTP,
+		Timeout:         config.SendTimeout,
+		Limiter:         limiter,
+		// 关闭时不再等待，直接发送剩余的数据，避免 Close 被限速阻塞
+		Unthrottle: loopDie,
 	})
 	if err != nil {
 		return nil, err
@@ -43,15 +75,25 @@ func NewIngestProducer(config IngestProducerConfig) (Producer, error) {
 	consumer := IngestProducer{
 		status:       running,
 		config:       &config,
-		buffer:       make([]*client.Message, 0, config.MaxBufferRecords),
 		ingestClient: ingestClient,
-		sendTimer:    time.NewTimer(config.SendInterval),
-		reportChan:   make(chan *client.Message),
-		loopDie:      make(chan struct{}),
-		loopExited:   make(chan struct{}),
+		limiter:      limiter,
+		scheduler:    config.Scheduler,
+		buffers:      make([][][]byte, len(lanes)),
+		lastSentAt:   time.Now(),
+		loopDie:      loopDie,
+	}
+	for range lanes {
+		ch := make(chan []byte)
+		consumer.reportChans = append(consumer.reportChans, ch)
+		consumer.laneChans = append(consumer.laneChans, ch)
+	}
+	if consumer.scheduler == nil {
+		consumer.scheduler = NewSendScheduler()
+		consumer.ownScheduler = true
 	}
 
-	go consumer.initConsumerLoop()
+	ingestClient.Start()
+	consumer.scheduler.add(&consumer)
 
 	DefaultLogger.Info("ModeSimple starting")
 
@@ -64,20 +106,20 @@ func (p *IngestProducer) Add(ctx context.Context, data map[string]interface{}) e
 	}
 
 	// marshal early to detect errors
-	b, err := marshalToBytes(data["data"])
+	record, err := encodeQueueRecord(data)
 	if err != nil {
 		return err
 	}
 
-	msg := client.Message{
-		Type: data["type"].(string),
-		Data: json.RawMessage(b),
+	lane := LaneNormal
+	if p.config.PriorityLanes {
+		lane = laneOf(ctx, data)
 	}
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case p.reportChan <- &msg:
+	case p.reportChans[slices.Index(lanes, lane)] <- record:
 		return nil
 	}
 }
@@ -85,53 +127,110 @@ func (p *IngestProducer) Add(ctx context.Context, data map[string]interface{}) e
 func (p *IngestProducer) Close(ctx context.Context) error {
 	if atomic.CompareAndSwapInt32(&p.status, running, stop) {
 		close(p.loopDie)
+		exited := make(chan struct{})
+		go func() {
+			defer close(exited)
+			// 等待正在进行的发送结束后发送剩余的数据
+			p.scheduler.remove(p)
+			if p.ownScheduler {
+				p.scheduler.Close()
+			}
+			p.sendBatch()
+			p.ingestClient.Close()
+		}()
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-p.loopExited:
+		case <-exited:
 			return nil
 		}
 	}
 	return nil
 }
 
-func (p *IngestProducer) initConsumerLoop() {
-	defer func() {
-		close(p.loopExited)
-	}()
-	for {
-		select {
-		case <-p.loopDie:
-			p.sendBatch()
-			return
-		case <-p.sendTimer.C:
-			p.sendBatch()
-		case data := <-p.reportChan:
-			p.buffer = append(p.buffer, data)
-			if len(p.buffer) >= p.config.MaxBufferRecords {
-				p.sendBatch()
-			}
+func (p *IngestProducer) sendInterval() time.Duration {
+	return p.config.SendInterval
+}
+
+func (p *IngestProducer) readChans() []<-chan []byte {
+	return p.laneChans
+}
+
+// preferredLane 数据直接从 Add 传递到调度协程，不按权重读取
+func (p *IngestProducer) preferredLane() int {
+	return -1
+}
+
+func (p *IngestProducer) receive(lane int, record []byte) func() {
+	p.buffers[lane] = append(p.buffers[lane], record)
+	if len(p.buffers[lane]) >= p.config.MaxBufferRecords {
+		return func() { p.sendLane(lane) }
+	}
+	return nil
+}
+
+func (p *IngestProducer) due() func() {
+	if time.Since(p.lastSentAt) < p.config.SendInterval {
+		return nil
+	}
+	for _, buffer := range p.buffers {
+		if len(buffer) > 0 {
+			return p.sendBatch
 		}
 	}
+	p.lastSentAt = time.Now()
+	return nil
 }
 
+// sendBatch 发送所有通道缓存的数据，高优先级通道先发送
 func (p *IngestProducer) sendBatch() {
-	p.sendTimer.Reset(p.config.SendInterval)
-	if len(p.buffer) <= 0 {
+	p.lastSentAt = time.Now()
+	for lane := range lanes {
+		p.sendLane(lane)
+	}
+}
+
+func (p *IngestProducer) sendLane(lane int) {
+	buffer := p.buffers[lane]
+	if len(buffer) <= 0 {
 		return
 	}
 
 	msgs := &client.Messages{}
-	for _, msg := range p.buffer {
-		msgs.Messages = append(msgs.Messages, *msg)
+	for _, record := range buffer {
+		msgType, msgData, err := DecodeQueueRecord(record)
+		if err != nil {
+			DefaultLogger.Errorf("decode message error when send data : %s", err)
+			continue
+		}
+		msgs.Messages = append(msgs.Messages, client.Message{
+			Type: msgType,
+			Data: json.RawMessage(msgData),
+		})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), p.config.SendTimeout)
-	defer cancel()
-
-	if err := p.ingestClient.Collect(ctx, msgs); err != nil {
+	// 超时时间由 ingestSender 控制，不包括限速等待的时间
+	if err := p.ingestClient.CollectSplitting(context.Background(), msgs, p.onOversized); err != nil {
 		DefaultLogger.Errorf("send data failed : %s", err)
 	}
 	// clear buffer
-	p.buffer = p.buffer[:0]
+	p.buffers[lane] = buffer[:0]
+}
+
+func (p *IngestProducer) SetRateLimit(limit RateLimit) {
+	p.limiter.setLimit(limit)
+}
+
+func (p *IngestProducer) ThrottleStatus() ThrottleStatus {
+	return p.limiter.throttleStatus()
+}
+
+// onOversized 丢弃单条就超过请求大小限制的数据
+func (p *IngestProducer) onOversized(msg client.Message, err error) {
+	data, _ := msg.Data.(json.RawMessage)
+	DefaultLogger.Errorf("discard %s message of %d bytes: %s", msg.Type, len(data), err)
+	if p.config.OnDiscard != nil {
+		msgTime := numberEncoding.Get(data, DataFieldNameTime).ToInt64()
+		p.config.OnDiscard(newDiscardedRecord(DiscardReasonTooLarge, msg.Type, data, msgTime))
+	}
 }
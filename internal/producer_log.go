@@ -9,13 +9,17 @@ import (
 )
 
 type LogProducerConfig struct {
-	Directory string
-	FileSize  int64
+	Directory   string
+	FileSize    int64
+	LockTimeout time.Duration
+	KeyProvider KeyProvider
 }
 
 type LogProducer struct {
 	status     int32
 	config     *LogProducerConfig
+	dirLock    *DirLock
+	cipher     *RecordCipher
 	dateFormat string
 	fileSize   int64
 	wg         sync.WaitGroup
@@ -29,9 +33,28 @@ type LogProducerRequest struct {
 }
 
 func NewLogProducer(config LogProducerConfig) (Producer, error) {
+	var recordCipher *RecordCipher
+	if config.KeyProvider != nil {
+		if err := checkKeyProvider(config.KeyProvider); err != nil {
+			return nil, err
+		}
+		recordCipher = NewRecordCipher(config.KeyProvider)
+	}
+
+	if err := os.MkdirAll(config.Directory, 0755); err != nil {
+		return nil, err
+	}
+
+	dirLock, err := LockDirectory(config.Directory, config.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	p := LogProducer{
 		status:     running,
 		config:     &config,
+		dirLock:    dirLock,
+		cipher:     recordCipher,
 		ch:         make(chan *LogProducerRequest),
 		dateFormat: time.DateOnly,
 		fileSize:   config.FileSize * 1024 * 1024,
@@ -47,6 +70,9 @@ func (p *LogProducer) Add(ctx context.Context, data map[string]interface{}) erro
 		err = ErrProducerClosed
 	} else {
 		jsonData, jsonErr := marshalToBytes(data)
+		if jsonErr == nil && p.cipher != nil {
+			jsonData, jsonErr = encryptLogLine(p.cipher, jsonData)
+		}
 		if jsonErr != nil {
 			err = jsonErr
 		} else {
@@ -93,6 +119,9 @@ func (p *LogProducer) init() error {
 func (p *LogProducer) runWork() {
 	defer func() {
 		atomic.StoreInt32(&p.status, stop)
+		if err := p.dirLock.Unlock(); err != nil {
+			DefaultLogger.Errorf("Unlock directory error : %s", err)
+		}
 		p.wg.Done()
 	}()
 
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit 发送到 ingest 的限速，0 表示不限制
+type RateLimit struct {
+	BytesPerSecond    int64   // 每秒发送的请求体字节数（压缩前）
+	RequestsPerSecond float64 // 每秒请求数，包括重试以及请求过大时拆分后的请求
+}
+
+// ThrottleStatus 限速状态
+type ThrottleStatus struct {
+	Limit             RateLimit
+	Throttling        bool          // 当前是否正在等待
+	ThrottledTimes    int64         // 累计被限速的请求数
+	ThrottledDuration time.Duration // 累计等待的时间
+}
+
+// RateLimitedProducer 支持限速的 Producer
+type RateLimitedProducer interface {
+	SetRateLimit(limit RateLimit)
+	ThrottleStatus() ThrottleStatus
+}
+
+// tokenBucket 令牌桶，容量为一秒的令牌数。令牌可以预支，
+// 因此超过容量的单次请求也能在等待相应的时间后发送
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// setRate 调整速率，从不限制变为限制时令牌桶是满的
+func (b *tokenBucket) setRate(rate float64, now time.Time) {
+	b.refill(now)
+	if b.rate <= 0 {
+		b.tokens = rate
+	}
+	b.rate = rate
+	b.tokens = math.Min(b.tokens, rate)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 && !b.last.IsZero() {
+		b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// reserve 取出 n 个令牌，返回需要等待的时间
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// delay 返回还清预支的令牌需要等待的时间
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	limit    RateLimit
+	bytes    tokenBucket
+	requests tokenBucket
+	status   ThrottleStatus
+	changed  chan struct{} // 限速调整时关闭并替换，通知正在等待的请求重新计算等待时间
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	l := &rateLimiter{changed: make(chan struct{})}
+	l.setLimit(limit)
+	return l
+}
+
+func (l *rateLimiter) setLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.limit = limit
+	l.bytes.setRate(float64(limit.BytesPerSecond), now)
+	l.requests.setRate(limit.RequestsPerSecond, now)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *rateLimiter) throttleStatus() ThrottleStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := l.status
+	status.Limit = l.limit
+	return status
+}
+
+// wait 等待发送一次 n 字节的请求，cancel 被关闭时返回 false。
+// 等待期间调整限速时按新的速率重新计算剩余的等待时间
+func (l *rateLimiter) wait(n int, cancel <-chan struct{}) bool {
+	l.mu.Lock()
+	start := time.Now()
+	d := max(l.bytes.reserve(float64(n), start), l.requests.reserve(1, start))
+	if d <= 0 {
+		l.mu.Unlock()
+		return true
+	}
+	l.status.Throttling = true
+	l.status.ThrottledTimes++
+	changed := l.changed
+	l.mu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var ok bool
+	for done := false; !done; {
+		select {
+		case <-timer.C:
+			ok, done = true, true
+		case <-cancel:
+			ok, done = false, true
+		case <-changed:
+			l.mu.Lock()
+			now := time.Now()
+			d = max(l.bytes.delay(now), l.requests.delay(now))
+			changed = l.changed
+			l.mu.Unlock()
+			timer.Reset(d)
+		}
+	}
+
+	l.mu.Lock()
+	l.status.Throttling = false
+	l.status.ThrottledDuration += time.Since(start)
+	l.mu.Unlock()
+	return ok
+}
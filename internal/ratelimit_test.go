@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{}
+	assert.Equal(t, time.Duration(0), b.reserve(1e9, now))
+
+	b.setRate(100, now)
+	assert.Equal(t, time.Duration(0), b.reserve(100, now))
+	assert.Equal(t, 500*time.Millisecond, b.reserve(50, now))
+
+	// 超过容量的请求预支令牌
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 2*time.Second, b.reserve(200, now))
+
+	// 空闲后最多积累一秒的令牌
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, time.Duration(0), b.reserve(100, now))
+	assert.Equal(t, 10*time.Millisecond, b.reserve(1, now))
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(RateLimit{RequestsPerSecond: 20})
+	for i := 0; i < 20; i++ {
+		assert.True(t, l.wait(1, nil))
+	}
+	assert.Equal(t, int64(0), l.throttleStatus().ThrottledTimes)
+
+	start := time.Now()
+	assert.True(t, l.wait(1, nil))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	status := l.throttleStatus()
+	assert.Equal(t, int64(1), status.ThrottledTimes)
+	assert.False(t, status.Throttling)
+	assert.Equal(t, 20.0, status.Limit.RequestsPerSecond)
+
+	// 等待期间关闭
+	l.setLimit(RateLimit{BytesPerSecond: 10})
+	cancel := make(chan struct{})
+	close(cancel)
+	assert.True(t, l.wait(10, cancel))
+	assert.False(t, l.wait(10, cancel))
+
+	// 取消限速后不再等待
+	l.setLimit(RateLimit{})
+	assert.True(t, l.wait(1<<30, nil))
+	assert.Equal(t, int64(2), l.throttleStatus().ThrottledTimes)
+
+	// 等待期间提高限速，按新的速率重新计算等待时间
+	l.setLimit(RateLimit{BytesPerSecond: 10})
+	assert.True(t, l.wait(10, nil))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.setLimit(RateLimit{BytesPerSecond: 1 << 20})
+	}()
+	start = time.Now()
+	assert.True(t, l.wait(1<<10, nil)) // 原本需要等待 100s
+	assert.Less(t, time.Since(start), 5*time.Second)
+	status = l.throttleStatus()
+	assert.Equal(t, int64(3), status.ThrottledTimes)
+	assert.Less(t, status.ThrottledDuration, 5*time.Second)
+
+	// 等待期间取消限速
+	l.setLimit(RateLimit{BytesPerSecond: 10})
+	assert.True(t, l.wait(10, nil))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.setLimit(RateLimit{})
+	}()
+	start = time.Now()
+	assert.True(t, l.wait(1<<10, nil))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
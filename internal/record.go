@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// 异步模式磁盘队列中单条记录的格式
+//
+// 旧版本: 完整的 {"data":{...},"type":"Event"} JSON，发送前需要重新解析
+// v1:    0x01 | 类型长度 (1 byte) | 类型 | data JSON，发送时直接使用 data 的原始字节
+const queueRecordV1 byte = 0x01
+
+var ErrMalformedQueueRecord = errors.New("malformed queue record")
+
+func encodeQueueRecord(data map[string]interface{}) ([]byte, error) {
+	msgType, ok := data["type"].(string)
+	if !ok || msgType == "" || len(msgType) > 255 {
+		return nil, fmt.Errorf("invalid message type: %v", data["type"])
+	}
+
+	b, err := marshalToBytes(data["data"])
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, 0, 2+len(msgType)+len(b))
+	record = append(record, queueRecordV1, byte(len(msgType)))
+	record = append(record, msgType...)
+	record = append(record, b...)
+	return record, nil
+}
+
+// DecodeQueueRecord 解析磁盘队列中的一条记录，返回消息类型以及 data 的 JSON
+func DecodeQueueRecord(record []byte) (string, []byte, error) {
+	if len(record) == 0 {
+		return "", nil, ErrMalformedQueueRecord
+	}
+
+	switch record[0] {
+	case '{':
+		var msg struct {
+			Type string              `json:"type"`
+			Data jsoniter.RawMessage `json:"data"`
+		}
+		if err := numberEncoding.Unmarshal(record, &msg); err != nil {
+			return "", nil, fmt.Errorf("%w: %s", ErrMalformedQueueRecord, err)
+		}
+		return msg.Type, msg.Data, nil
+	case queueRecordV1:
+		if len(record) < 2 || len(record) < 2+int(record[1]) {
+			return "", nil, ErrMalformedQueueRecord
+		}
+		n := int(record[1])
+		return string(record[2 : 2+n]), record[2+n:], nil
+	default:
+		return "", nil, fmt.Errorf("%w: unknown format 0x%02x", ErrMalformedQueueRecord, record[0])
+	}
+}
+
+// QueueRecordToJSON 将一条记录转换为旧版本的 {"data":{...},"type":"..."} JSON
+func QueueRecordToJSON(record []byte) ([]byte, error) {
+	if len(record) > 0 && record[0] == '{' {
+		return record, nil
+	}
+
+	msgType, data, err := DecodeQueueRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	quotedType, err := marshalToBytes(msgType)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(data)+len(quotedType)+18)
+	b = append(b, `{"data":`...)
+	b = append(b, data...)
+	b = append(b, `,"type":`...)
+	b = append(b, quotedType...)
+	b = append(b, '}')
+	return b, nil
+}
+
+// isQueueRecord 检查记录的基本结构，用于识别磁盘队列中损坏的记录
+func isQueueRecord(record []byte) bool {
+	var data []byte
+	if len(record) > 0 && record[0] == queueRecordV1 {
+		_, d, err := DecodeQueueRecord(record)
+		if err != nil {
+			return false
+		}
+		data = d
+	} else {
+		data = record
+	}
+	return len(data) >= 2 && data[0] == '{' && data[len(data)-1] == '}'
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueRecord(t *testing.T) {
+	data := map[string]interface{}{
+		"type": EventTypeValue,
+		"data": map[string]interface{}{
+			DataFieldNameEvent: "UserLogin",
+			DataFieldNameTime:  int64(1700000000123),
+			"big":              int64(1<<62 + 1),
+		},
+	}
+
+	record, err := encodeQueueRecord(data)
+	assert.Nil(t, err)
+	assert.Equal(t, queueRecordV1, record[0])
+	assert.True(t, isQueueRecord(record))
+
+	msgType, msgData, err := DecodeQueueRecord(record)
+	assert.Nil(t, err)
+	assert.Equal(t, EventTypeValue, msgType)
+	assert.Equal(t, `{"#event":"UserLogin","#time":1700000000123,"big":4611686018427387905}`, string(msgData))
+
+	// 转换为旧版本格式后与旧版本直接序列化的结果一致
+	legacy, err := marshalToBytes(data)
+	assert.Nil(t, err)
+	converted, err := QueueRecordToJSON(record)
+	assert.Nil(t, err)
+	assert.Equal(t, string(legacy), string(converted))
+}
+
+// 测试兼容旧版本写入磁盘队列的记录
+func TestLegacyQueueRecord(t *testing.T) {
+	legacy := []byte(`{"data":{"#event":"UserLogin","#time":1700000000123},"type":"Event"}`)
+	assert.True(t, isQueueRecord(legacy))
+
+	msgType, msgData, err := DecodeQueueRecord(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, EventTypeValue, msgType)
+	assert.Equal(t, `{"#event":"UserLogin","#time":1700000000123}`, string(msgData))
+
+	converted, err := QueueRecordToJSON(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, legacy, converted)
+
+	for _, malformed := range [][]byte{nil, {queueRecordV1}, {queueRecordV1, 10, 'E'}, []byte("garbage")} {
+		_, _, err = DecodeQueueRecord(malformed)
+		assert.ErrorIs(t, err, ErrMalformedQueueRecord)
+		assert.False(t, isQueueRecord(malformed))
+	}
+}
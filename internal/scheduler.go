@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// laneReadGrace 等待按权重选出的通道的最长时间，超过后等待所有通道
+const laneReadGrace = 10 * time.Millisecond
+
+// scheduledProducer 由 SendScheduler 调度发送的 producer
+//
+// 调度协程只在该 producer 没有正在进行的发送任务时调用 readChans、preferredLane、receive 与 due，
+// 返回的发送任务在单独的协程中执行，执行期间调度协程不会读取该 producer 的通道
+type scheduledProducer interface {
+	sendInterval() time.Duration
+	readChans() []<-chan []byte             // 各通道读取数据的 channel，暂停发送时返回 nil
+	preferredLane() int                     // 按权重优先读取的通道，没有确定有数据的通道时返回 -1
+	receive(lane int, record []byte) func() // 处理读取的数据，需要发送时返回发送任务
+	due() func()                            // 超过发送间隔时返回发送任务
+}
+
+// SendScheduler 调度一个或多个 producer 的发送，Client 本身与各路由目标共用一个 SendScheduler
+//
+// 一个调度协程轮流读取各 producer 的通道，按批次大小与发送间隔决定发送的时机；
+// 各 producer 使用自己的缓冲区、访问地址与访问 key 在单独的协程中发送，同一个 producer 同时只有一个发送任务，
+// 一个目标发送失败重试时不影响其他目标
+type SendScheduler struct {
+	ctrl      chan func()          // 在调度协程中执行，用于加入与移除 producer
+	wake      chan struct{}        // 暂停或恢复发送后重新选择读取的通道
+	done      chan *scheduledEntry // 发送任务结束
+	stop      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+
+	// 以下字段只在调度协程中访问
+	entries []*scheduledEntry
+	next    int // 下一次优先读取的 producer，各 producer 轮流读取
+	ticker  *time.Ticker
+}
+
+type scheduledEntry struct {
+	p       scheduledProducer
+	sending bool
+	jobs    sync.WaitGroup
+}
+
+// NewSendScheduler 创建并启动调度协程，需要在所有 producer 关闭后调用 Close
+func NewSendScheduler() *SendScheduler {
+	s := &SendScheduler{
+		ctrl:   make(chan func()),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan *scheduledEntry),
+		stop:   make(chan struct{}),
+		exited: make(chan struct{}),
+		ticker: time.NewTicker(time.Second),
+	}
+	go s.run()
+	return s
+}
+
+// Close 停止调度协程
+func (s *SendScheduler) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.exited
+	})
+}
+
+// exec 在调度协程中执行 fn，调度协程已经停止时返回 false
+func (s *SendScheduler) exec(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case s.ctrl <- func() { fn(); close(done) }:
+		<-done
+		return true
+	case <-s.exited:
+		return false
+	}
+}
+
+func (s *SendScheduler) add(p scheduledProducer) {
+	s.exec(func() {
+		s.entries = append(s.entries, &scheduledEntry{p: p})
+		s.resetTicker()
+	})
+}
+
+// remove 停止调度 p，并等待正在进行的发送任务结束
+func (s *SendScheduler) remove(p scheduledProducer) {
+	var removed *scheduledEntry
+	s.exec(func() {
+		for i, e := range s.entries {
+			if e.p == p {
+				removed = e
+				s.entries = append(s.entries[:i], s.entries[i+1:]...)
+				break
+			}
+		}
+		s.resetTicker()
+	})
+	if removed != nil {
+		removed.jobs.Wait()
+	}
+}
+
+// wakeup 通知调度协程重新选择读取的通道
+func (s *SendScheduler) wakeup() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// resetTicker 按最小的发送间隔检查各 producer 是否需要发送
+func (s *SendScheduler) resetTicker() {
+	interval := time.Duration(0)
+	for _, e := range s.entries {
+		if d := e.p.sendInterval(); d > 0 && (interval == 0 || d < interval) {
+			interval = d
+		}
+	}
+	if interval > 0 {
+		s.ticker.Reset(interval)
+	}
+}
+
+// dispatch 在单独的协程中执行发送任务，结束前不再读取该 producer 的通道
+func (s *SendScheduler) dispatch(e *scheduledEntry, job func()) {
+	if job == nil {
+		return
+	}
+	e.sending = true
+	e.jobs.Add(1)
+	go func() {
+		job()
+		e.jobs.Done()
+		select {
+		case s.done <- e:
+		case <-s.exited:
+		}
+	}()
+}
+
+// nextRead 从下一个 producer 开始，返回按权重优先读取的通道
+func (s *SendScheduler) nextRead() (*scheduledEntry, int, <-chan []byte) {
+	for i := range s.entries {
+		e := s.entries[(s.next+i)%len(s.entries)]
+		if e.sending {
+			continue
+		}
+		lane := e.p.preferredLane()
+		if chans := e.p.readChans(); lane >= 0 && lane < len(chans) && chans[lane] != nil {
+			s.next = (s.next + i + 1) % len(s.entries)
+			return e, lane, chans[lane]
+		}
+	}
+	return nil, -1, nil
+}
+
+// 调度协程 select 的固定分支
+const (
+	caseStop = iota
+	caseCtrl
+	caseWake
+	caseDone
+	caseTick
+	caseRead
+)
+
+type readCase struct {
+	e    *scheduledEntry
+	lane int
+}
+
+func (s *SendScheduler) run() {
+	defer close(s.exited)
+	defer s.ticker.Stop()
+
+	grace := time.NewTimer(laneReadGrace)
+	grace.Stop()
+	defer grace.Stop()
+
+	cases := []reflect.SelectCase{
+		caseStop: {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.stop)},
+		caseCtrl: {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.ctrl)},
+		caseWake: {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.wake)},
+		caseDone: {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.done)},
+		caseTick: {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.ticker.C)},
+	}
+	selected := make([]reflect.SelectCase, 0, 16) // 每次 select 的分支，重复使用避免分配
+	var reads []readCase
+
+	// handle 处理固定分支，返回 false 表示停止调度
+	handle := func(chosen int, v reflect.Value) bool {
+		switch chosen {
+		case caseStop:
+			return false
+		case caseCtrl:
+			v.Interface().(func())()
+		case caseDone:
+			v.Interface().(*scheduledEntry).sending = false
+		case caseTick:
+			for _, e := range s.entries {
+				if !e.sending {
+					s.dispatch(e, e.p.due())
+				}
+			}
+		}
+		return true
+	}
+
+	for {
+		// 优先等待按权重选出的通道，同时处理其他事件
+		if e, lane, ch := s.nextRead(); e != nil {
+			grace.Reset(laneReadGrace)
+			selected = append(append(selected[:0], cases...),
+				reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)},
+				reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(grace.C)},
+			)
+			chosen, v, ok := reflect.Select(selected)
+			if chosen != caseRead+1 {
+				grace.Stop()
+			}
+			if chosen < caseRead {
+				if !handle(chosen, v) {
+					return
+				}
+				continue
+			}
+			if chosen == caseRead {
+				if ok {
+					s.dispatch(e, e.p.receive(lane, v.Bytes()))
+				}
+				continue
+			}
+			// 队列深度不准确时不会一直等待该通道，改为等待所有通道
+		}
+
+		// 所有通道都没有数据，或者选出的通道没有就绪时等待任意通道
+		selected = append(selected[:0], cases...)
+		reads = reads[:0]
+		for _, e := range s.entries {
+			if e.sending {
+				continue
+			}
+			for lane, ch := range e.p.readChans() {
+				if ch != nil {
+					selected = append(selected, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+					reads = append(reads, readCase{e, lane})
+				}
+			}
+		}
+		chosen, v, ok := reflect.Select(selected)
+		if chosen < caseRead {
+			if !handle(chosen, v) {
+				return
+			}
+			continue
+		}
+		if ok {
+			r := reads[chosen-caseRead]
+			s.dispatch(r.e, r.e.p.receive(r.lane, v.Bytes()))
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	client "github.com/funny/ingest-client-go-sdk/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试多个 producer 共用一个调度协程，一个目标发送失败重试时其他目标的数据仍然按时发送
+func TestSendSchedulerSharedProducers(t *testing.T) {
+	var received int64
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := decodeRequestBody(r)
+		var msgs client.Messages
+		json.Unmarshal(body, &msgs)
+		atomic.AddInt64(&received, int64(len(msgs.Messages)))
+		writeIngestResponse(w)
+	}))
+	defer healthy.Close()
+	var failed int64
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&failed, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	s := NewSendScheduler()
+	defer s.Close()
+	newProducer := func(endpoint string) Producer {
+		p, err := NewAsyncProducer(AsyncProducerConfig{
+			Directory:        t.TempDir(),
+			IngestEndpoint:   endpoint,
+			MaxBufferRecords: 10,
+			SendInterval:     50 * time.Millisecond,
+			SendTimeout:      100 * time.Millisecond,
+			BatchSize:        1 << 20,
+			Scheduler:        s,
+		})
+		assert.Nil(t, err)
+		return p
+	}
+	ok := newProducer(healthy.URL)
+	bad := newProducer(failing.URL)
+	simple, err := NewIngestProducer(IngestProducerConfig{
+		IngestEndpoint:   healthy.URL,
+		MaxBufferRecords: 10,
+		SendInterval:     50 * time.Millisecond,
+		SendTimeout:      time.Second,
+		BatchSize:        1 << 20,
+		Scheduler:        s,
+	})
+	assert.Nil(t, err)
+
+	data := map[string]interface{}{
+		"type": EventTypeValue,
+		"data": map[string]interface{}{DataFieldNameEvent: "UserLogin"},
+	}
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, bad.Add(context.Background(), data))
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&failed) > 0 }, 3*time.Second, 10*time.Millisecond)
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, ok.Add(context.Background(), data))
+		assert.Nil(t, simple.Add(context.Background(), data))
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&received) == 50 }, 3*time.Second, 10*time.Millisecond)
+
+	// 关闭一个 producer 不影响其他 producer
+	assert.Nil(t, bad.Close(context.Background()))
+	assert.Nil(t, ok.Add(context.Background(), data))
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&received) == 51 }, 3*time.Second, 10*time.Millisecond)
+	assert.Nil(t, ok.Close(context.Background()))
+	assert.Nil(t, simple.Close(context.Background()))
+}
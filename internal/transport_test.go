@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeIngestResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"error":null}`))
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	assert.Nil(t, err)
+}
+
+// newClientCert 生成自签名的客户端证书，返回证书与私钥文件
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "funnydb-sdk-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return cert, certFile, keyFile
+}
+
+func collectWith(t *testing.T, endpoint string, httpConfig HTTPConfig) error {
+	s, err := newIngestSender(ingestSenderConfig{Endpoints: []string{endpoint}, HTTP: httpConfig})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Collect(ctx, newTestMessages(1))
+}
+
+func TestHTTPConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := newClientCert(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeIngestResponse(w)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	// 私有 CA + 客户端证书
+	assert.Nil(t, collectWith(t, srv.URL, HTTPConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}))
+	// 缺少客户端证书
+	assert.NotNil(t, collectWith(t, srv.URL, HTTPConfig{CAFile: caFile}))
+	// 不信任服务端证书
+	assert.NotNil(t, collectWith(t, srv.URL, HTTPConfig{CertFile: certFile, KeyFile: keyFile}))
+
+	_, err := newHTTPClient(HTTPConfig{CAFile: keyFile})
+	assert.ErrorIs(t, err, ErrCAFileIllegal)
+	_, err = newHTTPClient(HTTPConfig{CertFile: certFile})
+	assert.NotNil(t, err)
+}
+
+func TestHTTPConfigProxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.URL.Host == "ingest.example.com" && r.URL.Path == "/v1/collect" {
+			atomic.AddInt32(&proxied, 1)
+		}
+		writeIngestResponse(w)
+	}))
+	defer proxy.Close()
+
+	proxyURL := "http://user:secret@" + proxy.Listener.Addr().String()
+	assert.Nil(t, collectWith(t, "http://ingest.example.com", HTTPConfig{ProxyURL: proxyURL, MaxConnsPerHost: 4}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&proxied))
+
+	err := collectWith(t, "http://ingest.example.com", HTTPConfig{ProxyURL: "http://" + proxy.Listener.Addr().String()})
+	assert.NotNil(t, err)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTPConfigTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeIngestResponse(w)
+	}))
+	defer srv.Close()
+
+	var calls int32
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return srv.Client().Transport.RoundTrip(req)
+	})
+	assert.Nil(t, collectWith(t, srv.URL, HTTPConfig{Transport: transport, ProxyURL: "http://127.0.0.1:1"}))
+	assert.Nil(t, collectWith(t, srv.URL, HTTPConfig{Client: &http.Client{Transport: transport}}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+type closeCountingTransport struct {
+	http.RoundTripper
+	closes int32
+}
+
+func (t *closeCountingTransport) CloseIdleConnections() {
+	atomic.AddInt32(&t.closes, 1)
+}
+
+// 测试不会断开调用方提供的连接池中的连接
+func TestHTTPConfigCallerPoolKeepAlive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Close {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeIngestResponse(w)
+	}))
+	defer srv.Close()
+
+	for _, newConfig := range []func(*closeCountingTransport) HTTPConfig{
+		func(transport *closeCountingTransport) HTTPConfig { return HTTPConfig{Transport: transport} },
+		func(transport *closeCountingTransport) HTTPConfig {
+			return HTTPConfig{Client: &http.Client{Transport: transport}}
+		},
+	} {
+		transport := &closeCountingTransport{RoundTripper: srv.Client().Transport}
+		s, err := newIngestSender(ingestSenderConfig{Endpoints: []string{srv.URL}, HTTP: newConfig(transport)})
+		assert.Nil(t, err)
+		for i := 0; i < 2*closeIdleConnsInterval; i++ {
+			assert.Nil(t, s.Collect(context.Background(), newTestMessages(1)))
+		}
+		assert.Equal(t, int32(0), atomic.LoadInt32(&transport.closes))
+	}
+}
@@ -0,0 +1,72 @@
+package funnydb
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrRecordEmpty = errors.New("record has neither event nor mutation")
+var ErrRecordNotEvent = errors.New("ReportEvents middleware can not replace event with mutation")
+
+// Record 经过中间件的一条数据，Event 与 Mutation 只有一个不为 nil。
+// 中间件可以修改其中的数据，或者替换为新的 Event / Mutation
+type Record struct {
+	Event    *Event
+	Mutation *Mutation
+}
+
+// Handler 处理一条数据，最内层的 Handler 校验、转换数据并写入 producer
+type Handler func(ctx context.Context, r *Record) error
+
+// Middleware 包装 Handler，可以在调用 next 之前修改或丢弃数据（不调用 next 并返回 nil），
+// 以及在调用 next 之后得到上报结果
+type Middleware func(next Handler) Handler
+
+// Use 添加中间件，先添加的中间件在外层，最先看到数据
+func (c *Client) Use(middlewares ...Middleware) {
+	if len(middlewares) == 0 {
+		return
+	}
+	c.middlewareMu.Lock()
+	defer c.middlewareMu.Unlock()
+
+	c.middlewares = append(c.middlewares[:len(c.middlewares):len(c.middlewares)], middlewares...)
+	c.handler = wrap(c.middlewares, c.report)
+}
+
+// chain 返回以 h 为最内层的中间件链，没有中间件时返回 h
+func (c *Client) chain(h Handler) Handler {
+	c.middlewareMu.RLock()
+	middlewares := c.middlewares
+	c.middlewareMu.RUnlock()
+	return wrap(middlewares, h)
+}
+
+func wrap(middlewares []Middleware, h Handler) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+func (c *Client) handle(ctx context.Context, r *Record) error {
+	c.middlewareMu.RLock()
+	h := c.handler
+	c.middlewareMu.RUnlock()
+	if h == nil {
+		return c.report(ctx, r)
+	}
+	return h(ctx, r)
+}
+
+// report 上报中间件处理后的数据
+func (c *Client) report(ctx context.Context, r *Record) error {
+	switch {
+	case r.Event != nil:
+		return c.reportEvent(ctx, r.Event)
+	case r.Mutation != nil:
+		return c.reportMutation(ctx, r.Mutation)
+	default:
+		return ErrRecordEmpty
+	}
+}
@@ -0,0 +1,206 @@
+package funnydb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingProducer 记录写入的数据，errFor 返回错误时写入失败
+type recordingProducer struct {
+	mu     sync.Mutex
+	data   []map[string]interface{}
+	errFor func(data map[string]interface{}) error
+}
+
+func (p *recordingProducer) Add(ctx context.Context, data map[string]interface{}) error {
+	if p.errFor != nil {
+		if err := p.errFor(data); err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.data = append(p.data, data)
+	return nil
+}
+
+func (p *recordingProducer) Close(ctx context.Context) error {
+	return nil
+}
+
+func newRecordingClient(t *testing.T, config *Config) (*Client, *recordingProducer) {
+	config.Mode = ModeNoop
+	config.DisableReportStats = true
+	c, err := NewClient(config)
+	assert.Nil(t, err)
+	p := &recordingProducer{}
+	c.p = p
+	return c, p
+}
+
+func dataOf(data map[string]interface{}) map[string]interface{} {
+	return data["data"].(map[string]interface{})
+}
+
+func TestClientMiddlewares(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, r *Record) error {
+				order = append(order, name)
+				return next(ctx, r)
+			}
+		}
+	}
+	// 删除敏感属性
+	scrub := func(next Handler) Handler {
+		return func(ctx context.Context, r *Record) error {
+			if r.Event != nil {
+				delete(r.Event.Props, "email")
+			}
+			return next(ctx, r)
+		}
+	}
+	// 丢弃调试事件
+	drop := func(next Handler) Handler {
+		return func(ctx context.Context, r *Record) error {
+			if r.Event != nil && r.Event.Name == "Debug" {
+				return nil
+			}
+			return next(ctx, r)
+		}
+	}
+
+	c, p := newRecordingClient(t, &Config{Middlewares: []Middleware{trace("a"), scrub}})
+	c.Use(trace("b"), drop)
+
+	ctx := context.Background()
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{"email": "a@b.c", "level": 1}}))
+	assert.Equal(t, []string{"a", "b"}, order)
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "Debug", Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportMutation(ctx, &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet, Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportEvents(ctx, []*Event{
+		{Name: "Debug", Props: map[string]interface{}{}},
+		{Name: "UserLogout", Props: map[string]interface{}{}},
+	}))
+
+	assert.Len(t, p.data, 3)
+	assert.Equal(t, "UserLogin", dataOf(p.data[0])[internal.DataFieldNameEvent])
+	assert.NotContains(t, dataOf(p.data[0]), "email")
+	assert.Equal(t, MutationTypeUser, p.data[1]["type"])
+	assert.Equal(t, "UserLogout", dataOf(p.data[2])[internal.DataFieldNameEvent])
+}
+
+func TestClientMiddlewareResult(t *testing.T) {
+	errProducer := errors.New("producer error")
+	var results []error
+	observe := func(next Handler) Handler {
+		return func(ctx context.Context, r *Record) error {
+			err := next(ctx, r)
+			results = append(results, err)
+			return err
+		}
+	}
+	// 替换为新的事件
+	rename := func(next Handler) Handler {
+		return func(ctx context.Context, r *Record) error {
+			if r.Event != nil && r.Event.Name == "Old" {
+				r.Event = &Event{Name: "New", Props: r.Event.Props}
+			}
+			return next(ctx, r)
+		}
+	}
+
+	c, p := newRecordingClient(t, &Config{Middlewares: []Middleware{observe, rename}})
+	p.errFor = func(data map[string]interface{}) error {
+		if dataOf(data)[internal.DataFieldNameEvent] == "Fail" {
+			return errProducer
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "Old", Props: map[string]interface{}{}}))
+	assert.ErrorIs(t, c.ReportEvent(ctx, &Event{Name: "", Props: map[string]interface{}{}}), ErrEventDataNameIllegal)
+	assert.NotNil(t, c.ReportEvent(ctx, &Event{Name: "Fail", Props: map[string]interface{}{}}))
+	assert.ErrorIs(t, c.handle(ctx, &Record{}), ErrRecordEmpty)
+
+	assert.Len(t, results, 4)
+	assert.Nil(t, results[0])
+	assert.ErrorIs(t, results[1], ErrEventDataNameIllegal)
+	assert.Contains(t, results[2].Error(), errProducer.Error())
+	assert.Len(t, p.data, 1)
+	assert.Equal(t, "New", dataOf(p.data[0])[internal.DataFieldNameEvent])
+}
+
+// batchRecordingProducer 记录 AddBatch 的调用次数
+type batchRecordingProducer struct {
+	recordingProducer
+	batches int
+}
+
+func (p *batchRecordingProducer) AddBatch(ctx context.Context, batch []map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches++
+	p.data = append(p.data, batch...)
+	return nil
+}
+
+func TestClientReportEventsMiddlewares(t *testing.T) {
+	var results []error
+	scrub := func(next Handler) Handler {
+		return func(ctx context.Context, r *Record) error {
+			delete(r.Event.Props, "email")
+			if r.Event.Name == "Debug" {
+				return nil
+			}
+			err := next(ctx, r)
+			results = append(results, err)
+			return err
+		}
+	}
+	c, _ := newRecordingClient(t, &Config{Middlewares: []Middleware{scrub}})
+	p := &batchRecordingProducer{}
+	c.p = p
+	ctx := context.Background()
+
+	// 中间件处理后整批写入一次
+	assert.Nil(t, c.ReportEvents(ctx, []*Event{
+		{Name: "UserLogin", Props: map[string]interface{}{"email": "a@b.c"}},
+		{Name: "Debug", Props: map[string]interface{}{}},
+		{Name: "UserLogout", Props: map[string]interface{}{}},
+	}))
+	assert.Equal(t, 1, p.batches)
+	assert.Len(t, p.data, 2)
+	assert.NotContains(t, dataOf(p.data[0]), "email")
+	assert.Equal(t, "UserLogout", dataOf(p.data[1])[internal.DataFieldNameEvent])
+	assert.Equal(t, []error{nil, nil}, results)
+
+	// 任意一条校验失败时整批都不写入，中间件得到校验结果
+	err := c.ReportEvents(ctx, []*Event{
+		{Name: "UserLogin", Props: map[string]interface{}{}},
+		{Name: "", Props: map[string]interface{}{}},
+	})
+	assert.ErrorIs(t, err, ErrEventDataNameIllegal)
+	assert.ErrorIs(t, results[len(results)-1], ErrEventDataNameIllegal)
+	assert.Equal(t, 1, p.batches)
+	assert.Len(t, p.data, 2)
+
+	// 全部被丢弃时不写入
+	assert.Nil(t, c.ReportEvents(ctx, []*Event{{Name: "Debug", Props: map[string]interface{}{}}}))
+	assert.Equal(t, 1, p.batches)
+
+	// 不能替换为 Mutation
+	c.Use(func(next Handler) Handler {
+		return func(ctx context.Context, r *Record) error {
+			return next(ctx, &Record{Mutation: &Mutation{}})
+		}
+	})
+	assert.ErrorIs(t, c.ReportEvents(ctx, []*Event{{Name: "UserLogin", Props: map[string]interface{}{}}}), ErrRecordNotEvent)
+}
@@ -26,18 +26,22 @@ type Mutation struct {
 	Identity string
 	Operate  string
 	Props    map[string]interface{}
+
+	RoutingKey string // 按 Config.Routes 选择发送的目标，不会上报
 }
 
+// transformToReportableData 转换为上报的数据，不会修改 Mutation 本身
 func (m *Mutation) transformToReportableData(hostname string) (map[string]interface{}, error) {
 	dataMap := make(map[string]interface{})
 	dataMap[internal.DataFieldNameSdkType] = internal.SdkType
 	dataMap[internal.DataFieldNameSdkVersion] = internal.SdkVersion
 	dataMap[internal.DataFieldNameHostname] = hostname
 
-	if m.Time.IsZero() {
-		m.Time = time.Now()
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
 	}
-	dataMap[internal.DataFieldNameTime] = m.Time.UnixMilli()
+	dataMap[internal.DataFieldNameTime] = t.UnixMilli()
 
 	logId, err := internal.GenerateLogId()
 	if err != nil {
@@ -47,7 +51,11 @@ func (m *Mutation) transformToReportableData(hostname string) (map[string]interf
 
 	dataMap[internal.DataFieldNameOperate] = m.Operate
 	dataMap[internal.DataFieldNameIdentify] = m.Identity
-	dataMap[internal.DataFieldNameProperties] = m.Props
+	props := make(map[string]interface{}, len(m.Props))
+	for k, v := range m.Props {
+		props[k] = v
+	}
+	dataMap[internal.DataFieldNameProperties] = props
 
 	return map[string]interface{}{
 		"type": m.Type,
@@ -51,4 +51,16 @@ func TestMutation_transformToReportableData(t *testing.T) {
 
 	dataMap2 := reportableData2["data"].(map[string]interface{})
 	assert.Equal(t, true, dataMap2[internal.DataFieldNameTime].(int64) >= eventTime.UnixMilli())
+	// 不修改传入的 Mutation
+	assert.True(t, m2.Time.IsZero())
+
+	// Props 为 nil 时上报空的属性
+	m3 := &Mutation{
+		Type:     MutationTypeUser,
+		Identity: identity,
+		Operate:  OperateTypeSet,
+	}
+	reportableData3, err := m3.transformToReportableData("localhost")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{}, reportableData3["data"].(map[string]interface{})[internal.DataFieldNameProperties])
 }
@@ -0,0 +1,199 @@
+package funnydb
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+)
+
+// 属性值的校验方式，见 Config.PropsValidation
+const (
+	PropsValidationStrict  = "strict"  // 存在不支持的属性值时返回错误，数据不上报
+	PropsValidationLenient = "lenient" // 删除不支持的属性并打印警告，其余属性正常上报
+)
+
+var ErrPropValueIllegal = errors.New("property value is not supported")
+var ErrConfigPropsValidationIllegal = errors.New("producer config PropsValidation must be one of strict, lenient")
+
+const maxPropDepth = 32 // 属性值嵌套的最大层数，超过时视为循环引用
+
+func checkPropsValidation(validation string) error {
+	switch validation {
+	case "", PropsValidationStrict, PropsValidationLenient:
+		return nil
+	default:
+		return ErrConfigPropsValidationIllegal
+	}
+}
+
+// normalizeProps 校验并原地转换属性值，props 必须是 SDK 创建的 map
+func (c *Client) normalizeProps(props map[string]interface{}) error {
+	for k, v := range props {
+		nv, err := normalizePropValue(k, v, 0)
+		if err == nil {
+			props[k] = nv
+			continue
+		}
+		if c.config.PropsValidation != PropsValidationLenient {
+			return err
+		}
+		internal.DefaultLogger.Warnf("drop property: %s", err)
+		delete(props, k)
+	}
+	return nil
+}
+
+// normalizePropValue 将属性值转换为 JSON 可以表示的值：
+// time.Time 转换为毫秒时间戳（与 #time 相同），[]byte 与 fmt.Stringer 转换为字符串，
+// 自定义的整数、浮点数等类型转换为对应的基础类型，json.Number 转换为整数或浮点数。
+// chan、func、complex、NaN 与 ±Inf 以及嵌套过深（循环引用）的值返回错误，path 为出错的属性
+func normalizePropValue(path string, v interface{}, depth int) (interface{}, error) {
+	if depth > maxPropDepth {
+		return nil, fmt.Errorf("%w: %s: nested too deep, maybe cyclic", ErrPropValueIllegal, path)
+	}
+
+	switch val := v.(type) {
+	case nil, bool, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v, nil
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return nil, fmt.Errorf("%w: %s: %v", ErrPropValueIllegal, path, val)
+		}
+		return v, nil
+	case float32:
+		if math.IsNaN(float64(val)) || math.IsInf(float64(val), 0) {
+			return nil, fmt.Errorf("%w: %s: %v", ErrPropValueIllegal, path, val)
+		}
+		return v, nil
+	case time.Time:
+		return val.UnixMilli(), nil
+	case *time.Time:
+		if val == nil {
+			return nil, nil
+		}
+		return val.UnixMilli(), nil
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := val.Float64()
+		if err != nil || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: %s: invalid json.Number %q", ErrPropValueIllegal, path, val)
+		}
+		return f, nil
+	case []byte:
+		return string(val), nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			nv, err := normalizePropValue(path+"."+k, item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = nv
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, item := range val {
+			nv, err := normalizePropValue(path+"["+strconv.Itoa(i)+"]", item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = nv
+		}
+		return s, nil
+	case json.Marshaler, encoding.TextMarshaler:
+		// 自定义了序列化方式的值保持不变
+		return v, nil
+	case fmt.Stringer:
+		return val.String(), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return normalizePropValue(path, rv.Float(), depth)
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return normalizePropValue(path, rv.Elem().Interface(), depth+1)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 && rv.Kind() == reflect.Slice {
+			return string(rv.Bytes()), nil
+		}
+		s := make([]interface{}, rv.Len())
+		for i := range s {
+			nv, err := normalizePropValue(path+"["+strconv.Itoa(i)+"]", rv.Index(i).Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = nv
+		}
+		return s, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := propKey(iter.Key())
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", ErrPropValueIllegal, path, err)
+			}
+			nv, err := normalizePropValue(path+"."+k, iter.Value().Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = nv
+		}
+		return m, nil
+	case reflect.Struct:
+		// 结构体按 encoding/json 的规则序列化，这里只检查能否序列化
+		if _, err := json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrPropValueIllegal, path, err)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%w: %s: unsupported type %T", ErrPropValueIllegal, path, v)
+	}
+}
+
+// propKey 将 map 的 key 转换为字符串，规则与 encoding/json 相同
+func propKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported map key type %s", k.Type())
+	}
+}
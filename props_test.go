@@ -0,0 +1,115 @@
+package funnydb
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+type testLevel int
+
+type testChannel string
+
+type testColor int
+
+func (c testColor) String() string {
+	return [...]string{"red", "green"}[c]
+}
+
+type testItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestNormalizePropValue(t *testing.T) {
+	now := time.Now()
+	cyclic := map[string]interface{}{}
+	cyclic["self"] = cyclic
+
+	cases := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+		err   string
+	}{
+		{"int", 1, 1, ""},
+		{"float", 1.5, 1.5, ""},
+		{"time", now, now.UnixMilli(), ""},
+		{"time pointer", &now, now.UnixMilli(), ""},
+		{"bytes", []byte("abc"), "abc", ""},
+		{"stringer", testColor(1), "green", ""},
+		{"typed int", testLevel(3), int64(3), ""},
+		{"typed string", testChannel("a"), "a", ""},
+		{"json int", json.Number("12"), int64(12), ""},
+		{"json float", json.Number("1.25"), 1.25, ""},
+		{"typed slice", []testLevel{1, 2}, []interface{}{int64(1), int64(2)}, ""},
+		{"nested", map[string]interface{}{"at": now, "tags": []interface{}{testColor(0)}},
+			map[string]interface{}{"at": now.UnixMilli(), "tags": []interface{}{"red"}}, ""},
+		{"int key map", map[int]string{1: "a"}, map[string]interface{}{"1": "a"}, ""},
+		{"struct", testItem{Name: "a", Count: 1}, testItem{Name: "a", Count: 1}, ""},
+		{"nil pointer", (*testItem)(nil), nil, ""},
+		{"chan", make(chan int), nil, "v: unsupported type chan int"},
+		{"func", func() {}, nil, "v: unsupported type func()"},
+		{"nan", math.NaN(), nil, "v: NaN"},
+		{"inf", math.Inf(1), nil, "v: +Inf"},
+		{"nested nan", map[string]interface{}{"list": []interface{}{1, math.Inf(-1)}}, nil, "v.list[1]: -Inf"},
+		{"cyclic", cyclic, nil, "nested too deep"},
+		{"struct with chan", struct{ C chan int }{}, nil, "v: json: unsupported type"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizePropValue("v", tc.value, 0)
+			if tc.err != "" {
+				assert.ErrorIs(t, err, ErrPropValueIllegal)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestClientPropsValidation(t *testing.T) {
+	_, err := NewClient(&Config{Mode: ModeNoop, PropsValidation: "unknown"})
+	assert.Equal(t, ErrConfigPropsValidationIllegal, err)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// strict 模式下整条数据不上报
+	c, p := newRecordingClient(t, &Config{})
+	err = c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{"at": now, "callback": func() {}}})
+	assert.ErrorIs(t, err, ErrPropValueIllegal)
+	assert.Contains(t, err.Error(), "callback")
+	err = c.ReportEvents(ctx, []*Event{{Name: "UserLogin", Props: map[string]interface{}{"score": math.NaN()}}})
+	assert.ErrorIs(t, err, ErrPropValueIllegal)
+	err = c.ReportMutation(ctx, &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet,
+		Props: map[string]interface{}{"ch": make(chan int)}})
+	assert.ErrorIs(t, err, ErrPropValueIllegal)
+	assert.Len(t, p.data, 0)
+
+	// lenient 模式下删除不支持的属性
+	c, p = newRecordingClient(t, &Config{PropsValidation: PropsValidationLenient})
+	props := map[string]interface{}{"at": now, "callback": func() {}}
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: props}))
+	mutationProps := map[string]interface{}{"level": testLevel(2), "ch": make(chan int)}
+	assert.Nil(t, c.ReportMutation(ctx, &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet, Props: mutationProps}))
+
+	assert.Len(t, p.data, 2)
+	eventData := dataOf(p.data[0])
+	assert.Equal(t, now.UnixMilli(), eventData["at"])
+	assert.NotContains(t, eventData, "callback")
+	mutationData := dataOf(p.data[1])[internal.DataFieldNameProperties].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"level": int64(2)}, mutationData)
+
+	// 不修改传入的 Props
+	assert.Len(t, props, 2)
+	assert.Equal(t, now, props["at"])
+	assert.Len(t, mutationProps, 2)
+}
@@ -0,0 +1,62 @@
+package funnydb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+)
+
+// 属性中 # 开头字段的检查方式，见 Config.ReservedFieldCheck
+const (
+	ReservedFieldCheckWarn   = "warn"   // 打印警告（每个字段只打印一次），数据照常上报
+	ReservedFieldCheckReject = "reject" // 返回错误，数据不上报
+	ReservedFieldCheckNone   = "none"   // 不检查
+)
+
+var ErrReservedFieldIllegal = errors.New("property uses a reserved # field")
+var ErrConfigReservedFieldCheckIllegal = errors.New("producer config ReservedFieldCheck must be one of warn, reject, none")
+
+func checkReservedFieldCheck(check string) error {
+	switch check {
+	case "", ReservedFieldCheckWarn, ReservedFieldCheckReject, ReservedFieldCheckNone:
+		return nil
+	default:
+		return ErrConfigReservedFieldCheckIllegal
+	}
+}
+
+// checkReservedFields 检查属性中 # 开头的字段：
+// 事件中由 SDK 填充的字段（#log_id 除外）会被覆盖，
+// 未知的字段（不是预置字段，也不在 Config.AllowedReservedFields 中）多半是拼写错误
+func (c *Client) checkReservedFields(isEvent bool, props map[string]interface{}) error {
+	if c.config.ReservedFieldCheck == ReservedFieldCheckNone {
+		return nil
+	}
+	for k := range props {
+		if !strings.HasPrefix(k, "#") {
+			continue
+		}
+		var reason string
+		if internal.SdkDataFields[k] {
+			if !isEvent || k == internal.DataFieldNameLogId {
+				continue
+			}
+			reason = "set by sdk"
+		} else if internal.PresetDataFields[k] || slices.Contains(c.config.AllowedReservedFields, k) {
+			continue
+		} else {
+			reason = "unknown field"
+		}
+
+		if c.config.ReservedFieldCheck == ReservedFieldCheckReject {
+			return fmt.Errorf("%w: %s: %s", ErrReservedFieldIllegal, k, reason)
+		}
+		if _, warned := c.reservedWarned.LoadOrStore(k, true); !warned {
+			internal.DefaultLogger.Warnf("%s: %s: %s", ErrReservedFieldIllegal, k, reason)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package funnydb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientReservedFieldCheck(t *testing.T) {
+	_, err := NewClient(&Config{Mode: ModeNoop, ReservedFieldCheck: "unknown"})
+	assert.Equal(t, ErrConfigReservedFieldCheckIllegal, err)
+
+	ctx := context.Background()
+	newMutation := func(props map[string]interface{}) *Mutation {
+		return &Mutation{Type: MutationTypeDevice, Identity: "d1", Operate: OperateTypeSet, Props: props}
+	}
+
+	c, p := newRecordingClient(t, &Config{ReservedFieldCheck: ReservedFieldCheckReject, AllowedReservedFields: []string{"#zone"}})
+	// 预置字段、#log_id 以及允许的字段可以设置
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{
+		internal.DataFieldNameLogId: "log-1", internal.DataFieldNameIp: "127.0.0.1", internal.DataFieldNameAccountId: "a1", "#zone": "cn",
+	}}))
+	// 设备属性中可以包含客户端 SDK 的字段
+	assert.Nil(t, c.ReportMutation(ctx, newMutation(map[string]interface{}{
+		internal.DataFieldNameSdkType: "iOS", internal.DataFieldNameDeviceModel: "iPad11,1",
+	})))
+
+	err = c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{internal.DataFieldNameTime: 1}})
+	assert.ErrorIs(t, err, ErrReservedFieldIllegal)
+	assert.Contains(t, err.Error(), "#time: set by sdk")
+	err = c.ReportEvents(ctx, []*Event{{Name: "UserLogin", Props: map[string]interface{}{"#acount_id": "a1"}}})
+	assert.ErrorIs(t, err, ErrReservedFieldIllegal)
+	assert.Contains(t, err.Error(), "#acount_id: unknown field")
+	err = c.ReportMutation(ctx, newMutation(map[string]interface{}{"#devce_id": "d1"}))
+	assert.ErrorIs(t, err, ErrReservedFieldIllegal)
+	c.SetSuperProperty(internal.DataFieldNameEvent, "Other")
+	assert.ErrorIs(t, c.ReportEvent(ctx, &Event{Name: "UserLogin"}), ErrReservedFieldIllegal)
+	assert.Len(t, p.data, 2)
+	assert.Equal(t, "log-1", dataOf(p.data[0])[internal.DataFieldNameLogId])
+
+	// 默认只打印警告，SDK 字段仍以 SDK 填充的为准
+	c, p = newRecordingClient(t, &Config{})
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{internal.DataFieldNameEvent: "Other", "#acount_id": "a1"}}))
+	assert.Len(t, p.data, 1)
+	assert.Equal(t, "UserLogin", dataOf(p.data[0])[internal.DataFieldNameEvent])
+	assert.Equal(t, "a1", dataOf(p.data[0])["#acount_id"])
+	_, warned := c.reservedWarned.Load("#acount_id")
+	assert.True(t, warned)
+}
@@ -0,0 +1,116 @@
+package funnydb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+)
+
+var ErrConfigDestinationIllegal = errors.New("producer config Destinations name must be unique and only contain letters, digits, '_' and '-'")
+var ErrConfigRouteIllegal = errors.New("producer config Routes destination not found")
+
+var destinationNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Destination 路由的目标，使用独立的访问地址、访问 key 以及缓冲区（async 模式为独立的磁盘队列），
+// 未设置的字段沿用 Config 的设置。
+// 各目标与 Config 本身的目标共用 Client 的发送调度协程与统计协程：调度协程轮流读取各目标的缓冲区并决定发送时机，
+// 请求使用目标自己的访问地址、访问 key 与限速发送，一个目标发送失败重试时不影响其他目标；
+// 发送统计（#sdk_send_stats）按目标分别统计，使用目标的访问 key 上报
+type Destination struct {
+	Name string
+
+	IngestEndpoint  string
+	IngestEndpoints []string
+	AccessKey       string
+	AccessSecret    string
+
+	Directory string // persist_only 与 async 模式的存储文件夹，默认为 Config.Directory 下的 destinations/<Name>
+}
+
+// RouteRule 路由规则，设置的条件都满足时数据发送到 Destination，空的 Destination 表示 Config 本身的目标。
+// 规则按顺序匹配，都不满足时同样发送到 Config 本身的目标
+type RouteRule struct {
+	Events        []string // 事件名
+	MutationTypes []string // MutationTypeUser 或 MutationTypeDevice
+	RoutingKeys   []string // Event.RoutingKey 或 Mutation.RoutingKey
+
+	Destination string
+}
+
+// match 判断数据是否满足规则，events 与 mutationTypes 只检查与数据类型对应的一项
+func (r *RouteRule) match(isEvent bool, name string, routingKey string) bool {
+	if isEvent {
+		if len(r.MutationTypes) > 0 || (len(r.Events) > 0 && !slices.Contains(r.Events, name)) {
+			return false
+		}
+	} else {
+		if len(r.Events) > 0 || (len(r.MutationTypes) > 0 && !slices.Contains(r.MutationTypes, name)) {
+			return false
+		}
+	}
+	return len(r.RoutingKeys) == 0 || slices.Contains(r.RoutingKeys, routingKey)
+}
+
+func (c *Config) checkRoutes() error {
+	names := make(map[string]bool)
+	for _, d := range c.Destinations {
+		if !destinationNamePattern.MatchString(d.Name) || names[d.Name] {
+			return ErrConfigDestinationIllegal
+		}
+		names[d.Name] = true
+	}
+	for _, r := range c.Routes {
+		if r.Destination != "" && !names[r.Destination] {
+			return fmt.Errorf("%w: %s", ErrConfigRouteIllegal, r.Destination)
+		}
+	}
+	return nil
+}
+
+// destinationConfig 返回目标使用的配置
+func (c *Config) destinationConfig(d Destination) *Config {
+	config := *c
+	config.Destinations = nil
+	config.Routes = nil
+	if d.IngestEndpoint != "" || len(d.IngestEndpoints) > 0 {
+		config.IngestEndpoint = d.IngestEndpoint
+		config.IngestEndpoints = d.IngestEndpoints
+	}
+	if d.AccessKey != "" {
+		config.AccessKey = d.AccessKey
+		config.AccessSecret = d.AccessSecret
+	}
+	if d.Directory != "" {
+		config.Directory = d.Directory
+	} else if c.Directory != "" {
+		config.Directory = filepath.Join(c.Directory, "destinations", d.Name)
+	}
+	return &config
+}
+
+// producerFor 返回数据按路由规则使用的 producer，以及统计该数据的 StatCollector（不上报统计时为 nil）
+func (c *Client) producerFor(isEvent bool, name string, routingKey string) (internal.Producer, *StatCollector) {
+	for i := range c.config.Routes {
+		r := &c.config.Routes[i]
+		if r.match(isEvent, name, routingKey) {
+			if p, ok := c.destinations[r.Destination]; ok {
+				return p, c.destinationStats[r.Destination]
+			}
+			break
+		}
+	}
+	return c.p, c.stat
+}
+
+// producers 返回 Config 本身的目标以及所有路由目标的 producer
+func (c *Client) producers() []internal.Producer {
+	producers := []internal.Producer{c.p}
+	for _, d := range c.config.Destinations {
+		producers = append(producers, c.destinations[d.Name])
+	}
+	return producers
+}
@@ -0,0 +1,153 @@
+package funnydb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteRule_match(t *testing.T) {
+	byEvent := RouteRule{Events: []string{"PayOrder", "Refund"}}
+	assert.True(t, byEvent.match(true, "PayOrder", ""))
+	assert.False(t, byEvent.match(true, "UserLogin", ""))
+	assert.False(t, byEvent.match(false, MutationTypeUser, ""))
+
+	byMutation := RouteRule{MutationTypes: []string{MutationTypeUser}}
+	assert.True(t, byMutation.match(false, MutationTypeUser, ""))
+	assert.False(t, byMutation.match(false, MutationTypeDevice, ""))
+	assert.False(t, byMutation.match(true, "UserLogin", ""))
+
+	byKey := RouteRule{RoutingKeys: []string{"game-b"}}
+	assert.True(t, byKey.match(true, "UserLogin", "game-b"))
+	assert.True(t, byKey.match(false, MutationTypeDevice, "game-b"))
+	assert.False(t, byKey.match(true, "UserLogin", ""))
+
+	// 同时设置的条件都需要满足
+	both := RouteRule{Events: []string{"PayOrder"}, RoutingKeys: []string{"game-b"}}
+	assert.True(t, both.match(true, "PayOrder", "game-b"))
+	assert.False(t, both.match(true, "PayOrder", "game-a"))
+}
+
+func TestConfig_checkRoutes(t *testing.T) {
+	config := &Config{
+		Mode:         ModePersistOnly,
+		Directory:    t.TempDir(),
+		Destinations: []Destination{{Name: "game-b"}},
+		Routes:       []RouteRule{{RoutingKeys: []string{"b"}, Destination: "game-b"}, {Destination: ""}},
+	}
+	assert.Nil(t, config.checkConfig())
+
+	config.Destinations = []Destination{{Name: "game-b"}, {Name: "game-b"}}
+	assert.ErrorIs(t, config.checkConfig(), ErrConfigDestinationIllegal)
+	config.Destinations = []Destination{{Name: "../game-b"}}
+	assert.ErrorIs(t, config.checkConfig(), ErrConfigDestinationIllegal)
+	config.Destinations = []Destination{{Name: "game-c"}}
+	assert.ErrorIs(t, config.checkConfig(), ErrConfigRouteIllegal)
+}
+
+// readLogLines 读取 persist_only 模式写入的除统计数据以外的所有数据
+func readLogLines(t *testing.T, dir string) []string {
+	var lines []string
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*.log"))
+	assert.Nil(t, err)
+	for _, file := range matches {
+		content, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if !strings.Contains(line, statsEventName) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+func TestClientRouting(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewClient(&Config{
+		Mode:         ModePersistOnly,
+		Directory:    dir,
+		Destinations: []Destination{{Name: "payment"}, {Name: "game-b"}},
+		Routes: []RouteRule{
+			{Events: []string{"PayOrder"}, Destination: "payment"},
+			{MutationTypes: []string{MutationTypeDevice}, Destination: "payment"},
+			{RoutingKeys: []string{"game-b"}, Destination: "game-b"},
+		},
+	})
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "PayOrder", Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportEvents(ctx, []*Event{
+		{Name: "UserLogin", RoutingKey: "game-b", Props: map[string]interface{}{}},
+		{Name: "PayOrder", RoutingKey: "game-b", Props: map[string]interface{}{}},
+		{Name: "UserLogout", Props: map[string]interface{}{}},
+	}))
+	assert.Nil(t, c.ReportMutation(ctx, &Mutation{Type: MutationTypeDevice, Identity: "d1", Operate: OperateTypeSet, Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportMutation(ctx, &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet, Props: map[string]interface{}{}, RoutingKey: "game-b"}))
+	assert.Nil(t, c.Close(ctx))
+
+	assert.Len(t, readLogLines(t, dir), 2)
+	payment := readLogLines(t, filepath.Join(dir, "destinations", "payment"))
+	assert.Len(t, payment, 3)
+	gameB := readLogLines(t, filepath.Join(dir, "destinations", "game-b"))
+	assert.Len(t, gameB, 2)
+	assert.Contains(t, strings.Join(gameB, "\n"), `"#event":"UserLogin"`)
+	assert.Contains(t, strings.Join(gameB, "\n"), MutationTypeUser)
+	// RoutingKey 不会上报
+	assert.NotContains(t, strings.Join(gameB, "\n"), "RoutingKey")
+}
+
+// readStatLines 读取 persist_only 模式写入的统计数据
+func readStatLines(t *testing.T, dir string) string {
+	var lines []string
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*.log"))
+	assert.Nil(t, err)
+	for _, file := range matches {
+		content, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if strings.Contains(line, statsEventName) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 测试路由目标的数据由目标使用自己的访问 key 统计与上报
+func TestClientRoutingStats(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewClient(&Config{
+		Mode:         ModePersistOnly,
+		Directory:    dir,
+		AccessKey:    "main-key",
+		Destinations: []Destination{{Name: "payment", AccessKey: "payment-key"}},
+		Routes:       []RouteRule{{Events: []string{"PayOrder"}, Destination: "payment"}},
+	})
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "PayOrder", Props: map[string]interface{}{}}))
+	assert.Nil(t, c.ReportEvents(ctx, []*Event{{Name: "PayOrder", Props: map[string]interface{}{}}}))
+	assert.Nil(t, c.Close(ctx))
+
+	main := readStatLines(t, dir)
+	assert.Contains(t, main, `"report_event":"UserLogin"`)
+	assert.Contains(t, main, `"access_key_id":"main-key"`)
+	assert.NotContains(t, main, `"report_event":"PayOrder"`)
+	assert.NotContains(t, main, "payment-key")
+
+	payment := readStatLines(t, filepath.Join(dir, "destinations", "payment"))
+	assert.Contains(t, payment, `"report_event":"PayOrder"`)
+	assert.Contains(t, payment, `"report_total":2`)
+	assert.Contains(t, payment, `"access_key_id":"payment-key"`)
+	assert.NotContains(t, payment, `"report_event":"UserLogin"`)
+	assert.NotContains(t, payment, "main-key")
+}
@@ -0,0 +1,296 @@
+package funnydb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// 数据不符合 Schema 时的处理方式，见 Config.SchemaMode
+const (
+	SchemaModeReject = "reject" // 返回错误，数据不上报
+	SchemaModeWarn   = "warn"   // 打印警告，数据照常上报
+	SchemaModeTag    = "tag"    // 数据照常上报，事件在属性 SchemaErrorsProperty 中记录不符合的原因，Mutation 只打印警告
+)
+
+// SchemaErrorsProperty SchemaModeTag 下记录不符合原因的属性，值为字符串数组
+const SchemaErrorsProperty = "schema_errors"
+
+// 属性的类型，见 PropSchema.Type
+const (
+	PropTypeString  = "string"
+	PropTypeInteger = "integer"
+	PropTypeNumber  = "number" // 整数或浮点数
+	PropTypeBoolean = "boolean"
+	PropTypeArray   = "array"
+	PropTypeObject  = "object"
+)
+
+var ErrSchemaIllegal = errors.New("schema illegal")
+var ErrSchemaViolation = errors.New("data does not match schema")
+var ErrConfigSchemaModeIllegal = errors.New("producer config SchemaMode must be one of reject, warn, tag")
+
+// PropSchema 单个属性的约束，属性值在校验前已按 normalizePropValue 转换（time.Time 为毫秒时间戳等）
+type PropSchema struct {
+	Type      string        `yaml:"type" json:"type"`             // 见 PropType*，为空时不检查类型
+	Required  bool          `yaml:"required" json:"required"`     // 是否必须设置
+	Enum      []interface{} `yaml:"enum" json:"enum"`             // 允许的取值
+	MaxLength int           `yaml:"max_length" json:"max_length"` // 字符串的最大字符数或数组的最大长度，0 表示不限制
+}
+
+// EventSchema 一种事件（或一种 Mutation）的属性约束，未列出的属性不检查
+type EventSchema struct {
+	Props map[string]PropSchema `yaml:"props" json:"props"`
+}
+
+// Schema 按事件名与 Mutation 类型定义的属性约束，没有定义的事件与 Mutation 不检查。
+// 文件格式示例（YAML，JSON 的结构相同）：
+//
+//	events:
+//	  UserLogin:
+//	    props:
+//	      "#account_id": {type: string, required: true}
+//	      channel: {type: string, enum: [app_store, google_play]}
+//	mutations:
+//	  UserMutation:
+//	    props:
+//	      level: {type: integer}
+type Schema struct {
+	Events    map[string]EventSchema `yaml:"events" json:"events"`
+	Mutations map[string]EventSchema `yaml:"mutations" json:"mutations"` // key 为 MutationTypeUser 或 MutationTypeDevice
+}
+
+// LoadSchema 读取并合并 YAML 或 JSON（按 .json 扩展名区分）格式的 Schema 文件，
+// 同一个事件或 Mutation 类型只能在一个文件中定义
+func LoadSchema(files ...string) (*Schema, error) {
+	schema := &Schema{Events: make(map[string]EventSchema), Mutations: make(map[string]EventSchema)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read schema file: %w", err)
+		}
+		var s Schema
+		if strings.EqualFold(filepath.Ext(file), ".json") {
+			err = json.Unmarshal(data, &s)
+		} else {
+			err = yaml.Unmarshal(data, &s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrSchemaIllegal, file, err)
+		}
+		if err := mergeEventSchemas(schema.Events, s.Events); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := mergeEventSchemas(schema.Mutations, s.Mutations); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if err := schema.check(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func mergeEventSchemas(dst, src map[string]EventSchema) error {
+	for name, s := range src {
+		if _, ok := dst[name]; ok {
+			return fmt.Errorf("%w: %s defined more than once", ErrSchemaIllegal, name)
+		}
+		dst[name] = s
+	}
+	return nil
+}
+
+func (s *Schema) check() error {
+	for name := range s.Mutations {
+		if name != MutationTypeUser && name != MutationTypeDevice {
+			return fmt.Errorf("%w: mutations: %s: %s", ErrSchemaIllegal, name, ErrMutationTypeIllegal)
+		}
+	}
+	for _, schemas := range []map[string]EventSchema{s.Events, s.Mutations} {
+		for name, es := range schemas {
+			for prop, ps := range es.Props {
+				switch ps.Type {
+				case "", PropTypeString, PropTypeInteger, PropTypeNumber, PropTypeBoolean, PropTypeArray, PropTypeObject:
+				default:
+					return fmt.Errorf("%w: %s.%s: unknown type %s", ErrSchemaIllegal, name, prop, ps.Type)
+				}
+				if ps.MaxLength < 0 {
+					return fmt.Errorf("%w: %s.%s: max_length can not be negative", ErrSchemaIllegal, name, prop)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkSchemaMode(mode string) error {
+	switch mode {
+	case "", SchemaModeReject, SchemaModeWarn, SchemaModeTag:
+		return nil
+	default:
+		return ErrConfigSchemaModeIllegal
+	}
+}
+
+// validate 返回 props 不符合约束的原因，按属性名排序
+func (s *EventSchema) validate(props map[string]interface{}) []string {
+	names := make([]string, 0, len(s.Props))
+	for name := range s.Props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		ps := s.Props[name]
+		v, ok := props[name]
+		if !ok || v == nil {
+			if ps.Required {
+				problems = append(problems, name+": required")
+			}
+			continue
+		}
+		if ps.Type != "" && !matchPropType(ps.Type, v) {
+			problems = append(problems, fmt.Sprintf("%s: expect %s, got %T", name, ps.Type, v))
+			continue
+		}
+		if len(ps.Enum) > 0 && !slices.ContainsFunc(ps.Enum, func(e interface{}) bool { return propValueEqual(e, v) }) {
+			problems = append(problems, fmt.Sprintf("%s: %v not in enum", name, v))
+		}
+		if ps.MaxLength > 0 {
+			n := -1
+			switch val := v.(type) {
+			case string:
+				n = utf8.RuneCountInString(val)
+			case []interface{}:
+				n = len(val)
+			}
+			if n > ps.MaxLength {
+				problems = append(problems, fmt.Sprintf("%s: length %d exceeds %d", name, n, ps.MaxLength))
+			}
+		}
+	}
+	return problems
+}
+
+func matchPropType(typ string, v interface{}) bool {
+	switch typ {
+	case PropTypeString:
+		_, ok := v.(string)
+		return ok
+	case PropTypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case PropTypeArray:
+		_, ok := v.([]interface{})
+		return ok
+	case PropTypeObject:
+		_, ok := v.(map[string]interface{})
+		return ok || reflect.ValueOf(v).Kind() == reflect.Struct
+	case PropTypeInteger:
+		f, ok := propNumber(v)
+		return ok && f == math.Trunc(f)
+	case PropTypeNumber:
+		_, ok := propNumber(v)
+		return ok
+	}
+	return false
+}
+
+// propNumber 返回数字类型的属性值
+func propNumber(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// propValueEqual 比较枚举值与属性值，数字按数值比较（Schema 文件中的整数与属性中的 int64 等相等），
+// 数组与对象逐个元素比较
+func propValueEqual(a, b interface{}) bool {
+	if fa, ok := propNumber(a); ok {
+		fb, ok := propNumber(b)
+		return ok && fa == fb
+	}
+	switch va := a.(type) {
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		return ok && slices.EqualFunc(va, vb, propValueEqual)
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for k, v := range va {
+			if w, ok := vb[k]; !ok || !propValueEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// validateSchema 按 Schema 校验事件（isEvent）或 Mutation 的属性，props 必须是 SDK 创建的 map
+func (c *Client) validateSchema(isEvent bool, name string, props map[string]interface{}) error {
+	schema := c.schema.Load()
+	if schema == nil {
+		return nil
+	}
+	schemas, kind := schema.Events, "event"
+	if !isEvent {
+		schemas, kind = schema.Mutations, "mutation"
+	}
+	es, ok := schemas[name]
+	if !ok {
+		return nil
+	}
+	problems := es.validate(props)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	switch c.config.SchemaMode {
+	case SchemaModeTag:
+		// Mutation 的属性会写入用户或设备的属性，不记录不符合的原因
+		if isEvent {
+			props[SchemaErrorsProperty] = problems
+			break
+		}
+		fallthrough
+	case SchemaModeWarn:
+		internal.DefaultLogger.Warnf("%s: %s %s: %s", ErrSchemaViolation, kind, name, strings.Join(problems, "; "))
+	default:
+		return fmt.Errorf("%w: %s %s: %s", ErrSchemaViolation, kind, name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// SetSchema 替换校验使用的 Schema，例如重新加载 Schema 文件后调用，nil 表示不校验
+func (c *Client) SetSchema(schema *Schema) error {
+	if schema != nil {
+		if err := schema.check(); err != nil {
+			return err
+		}
+	}
+	c.schema.Store(schema)
+	return nil
+}
@@ -0,0 +1,134 @@
+package funnydb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchemaYAML = `
+events:
+  UserLogin:
+    props:
+      "#account_id": {type: string, required: true}
+      channel: {type: string, enum: [app_store, google_play]}
+      level: {type: integer, enum: [1, 2, 3]}
+      nickname: {type: string, max_length: 4}
+      login_at: {type: integer}
+`
+
+const testSchemaJSON = `{
+  "mutations": {
+    "UserMutation": {"props": {"vip": {"type": "boolean", "required": true}}}
+  }
+}`
+
+func writeSchemaFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadSchema(t *testing.T) {
+	yamlFile := writeSchemaFile(t, "events.yaml", testSchemaYAML)
+	jsonFile := writeSchemaFile(t, "mutations.json", testSchemaJSON)
+
+	schema, err := LoadSchema(yamlFile, jsonFile)
+	assert.Nil(t, err)
+	assert.Equal(t, PropSchema{Type: PropTypeString, Required: true}, schema.Events["UserLogin"].Props["#account_id"])
+	assert.Equal(t, []interface{}{"app_store", "google_play"}, schema.Events["UserLogin"].Props["channel"].Enum)
+	assert.Equal(t, 4, schema.Events["UserLogin"].Props["nickname"].MaxLength)
+	assert.True(t, schema.Mutations[MutationTypeUser].Props["vip"].Required)
+
+	_, err = LoadSchema(yamlFile, yamlFile)
+	assert.ErrorIs(t, err, ErrSchemaIllegal)
+	_, err = LoadSchema(writeSchemaFile(t, "bad.yaml", "events:\n  A:\n    props:\n      a: {type: date}\n"))
+	assert.ErrorIs(t, err, ErrSchemaIllegal)
+	_, err = LoadSchema(writeSchemaFile(t, "bad.json", `{"mutations": {"Other": {}}}`))
+	assert.ErrorIs(t, err, ErrSchemaIllegal)
+}
+
+func TestEventSchema_validate(t *testing.T) {
+	schema, err := LoadSchema(writeSchemaFile(t, "events.yaml", testSchemaYAML))
+	assert.Nil(t, err)
+	es := schema.Events["UserLogin"]
+
+	assert.Empty(t, es.validate(map[string]interface{}{
+		"#account_id": "a1", "channel": "app_store", "level": int64(2), "nickname": "小明", "login_at": 1.0e12, "other": true,
+	}))
+	assert.Equal(t, []string{
+		"#account_id: required",
+		"channel: steam not in enum",
+		"level: expect integer, got float64",
+		"nickname: length 5 exceeds 4",
+	}, es.validate(map[string]interface{}{"channel": "steam", "level": 1.5, "nickname": "abcde"}))
+
+	// 数组与对象的枚举值按元素比较
+	schema, err = LoadSchema(writeSchemaFile(t, "composite.yaml", `
+events:
+  Test:
+    props:
+      pos: {type: array, enum: [[1, 2], [3, 4]]}
+      meta: {type: object, enum: [{a: 1}]}
+      any: {enum: [[1], x]}
+`))
+	assert.Nil(t, err)
+	es = schema.Events["Test"]
+	assert.Empty(t, es.validate(map[string]interface{}{
+		"pos": []interface{}{int64(3), 4.0}, "meta": map[string]interface{}{"a": int64(1)}, "any": "x",
+	}))
+	assert.Equal(t, []string{
+		"any: [x] not in enum",
+		"meta: map[a:2] not in enum",
+		"pos: [1 2 3] not in enum",
+	}, es.validate(map[string]interface{}{
+		"pos": []interface{}{1, 2, 3}, "meta": map[string]interface{}{"a": 2}, "any": []interface{}{"x"},
+	}))
+}
+
+func TestClientSchema(t *testing.T) {
+	_, err := NewClient(&Config{Mode: ModeNoop, SchemaMode: "unknown"})
+	assert.Equal(t, ErrConfigSchemaModeIllegal, err)
+
+	schema, err := LoadSchema(writeSchemaFile(t, "events.yaml", testSchemaYAML), writeSchemaFile(t, "mutations.json", testSchemaJSON))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	// 默认拒绝，属性值按转换后的值校验，公共属性同样参与校验
+	c, p := newRecordingClient(t, &Config{Schema: schema, SuperProperties: map[string]interface{}{"channel": "app_store"}})
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{"#account_id": "a1", "login_at": time.Now()}}))
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogout"}))
+	err = c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{"channel": "steam"}})
+	assert.ErrorIs(t, err, ErrSchemaViolation)
+	assert.Contains(t, err.Error(), "event UserLogin: #account_id: required; channel: steam not in enum")
+	err = c.ReportEvents(ctx, []*Event{{Name: "UserLogin", Props: map[string]interface{}{"#account_id": 1}}})
+	assert.ErrorIs(t, err, ErrSchemaViolation)
+	err = c.ReportMutation(ctx, &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet, Props: map[string]interface{}{}})
+	assert.ErrorIs(t, err, ErrSchemaViolation)
+	assert.Nil(t, c.ReportMutation(ctx, &Mutation{Type: MutationTypeDevice, Identity: "d1", Operate: OperateTypeSet}))
+	assert.Len(t, p.data, 3)
+
+	// 替换后不再校验
+	assert.Nil(t, c.SetSchema(nil))
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin"}))
+
+	// 事件记录不符合的原因后照常上报
+	c, p = newRecordingClient(t, &Config{Schema: schema, SchemaMode: SchemaModeTag})
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin", Props: map[string]interface{}{"#account_id": "a1", "level": 4}}))
+	assert.Nil(t, c.ReportMutation(ctx, &Mutation{Type: MutationTypeUser, Identity: "u1", Operate: OperateTypeSet, Props: map[string]interface{}{"vip": 1}}))
+	assert.Equal(t, []string{"level: 4 not in enum"}, dataOf(p.data[0])[SchemaErrorsProperty])
+	// Mutation 只打印警告，不写入用户属性
+	mutationProps := dataOf(p.data[1])[internal.DataFieldNameProperties].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"vip": 1}, mutationProps)
+
+	// 只打印警告
+	c, p = newRecordingClient(t, &Config{Schema: schema, SchemaMode: SchemaModeWarn})
+	assert.Nil(t, c.ReportEvent(ctx, &Event{Name: "UserLogin"}))
+	assert.Len(t, p.data, 1)
+	assert.NotContains(t, dataOf(p.data[0]), SchemaErrorsProperty)
+}
@@ -9,7 +9,9 @@ import (
 )
 
 const (
-	statsEventName = "#sdk_send_stats"
+	statsEventName      = "#sdk_send_stats"
+	throttleReportEvent = "#throttle" // 限速统计的 report_event
+	statsReportInterval = 30 * time.Second
 )
 
 type StatCollector struct {
@@ -20,26 +22,23 @@ type StatCollector struct {
 	accessKeyId    string
 	initTime       time.Time
 	reportInterval time.Duration
-	stop           chan struct{}
-	die            chan struct{}
 	mu             sync.Mutex
 	stats          map[statKey]int64
+	lastReportTime time.Time
+	lastThrottle   internal.ThrottleStatus
 }
 
-func newStatCollector(producer internal.Producer, instanceID string, hostname string, reportMode Mode, accessKeyId string, reportInterval time.Duration) (*StatCollector, error) {
+func newStatCollector(instanceID string, hostname string, reportMode Mode, accessKeyId string, reportInterval time.Duration) (*StatCollector, error) {
 	sc := &StatCollector{
-		producer:       producer,
 		instanceID:     instanceID,
 		hostname:       hostname,
 		reportMode:     reportMode,
 		accessKeyId:    accessKeyId,
 		initTime:       time.Now(),
+		lastReportTime: time.Now(),
 		reportInterval: reportInterval,
-		stop:           make(chan struct{}),
-		die:            make(chan struct{}),
 		stats:          map[statKey]int64{},
 	}
-	go sc.ioLoop()
 	return sc, nil
 }
 
@@ -54,39 +53,87 @@ func (sc *StatCollector) Collect(eventTime time.Time, event string) {
 	sc.stats[key]++
 }
 
-func (sc *StatCollector) Close() {
-	close(sc.stop)
-	<-sc.die
+// CollectDiscarded 统计未发送而丢弃的数据
+func (sc *StatCollector) CollectDiscarded(eventTime time.Time, event string, reason string) {
+	beginTime := eventTime.Round(sc.reportInterval)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	key := statKey{
+		reportEvent:   event,
+		beginTime:     beginTime,
+		discardReason: reason,
+	}
+	sc.stats[key]++
 }
 
-func (sc *StatCollector) ioLoop() {
-	defer close(sc.die)
+// statLoop 定时上报多个 StatCollector 的统计，Client 本身与各路由目标的统计共用一个协程，
+// 各目标的统计使用目标的访问 key 由目标的 producer 上报
+type statLoop struct {
+	collectors []*StatCollector
+	interval   time.Duration
+	stop       chan struct{}
+	die        chan struct{}
+}
 
-	ticker := time.NewTicker(sc.reportInterval)
+// startStatLoop 开始定时上报，StatCollector 需要设置 producer，producer 创建前收集的统计数据同样会上报
+func startStatLoop(interval time.Duration, collectors []*StatCollector) *statLoop {
+	l := &statLoop{
+		collectors: collectors,
+		interval:   interval,
+		stop:       make(chan struct{}),
+		die:        make(chan struct{}),
+	}
+	go l.ioLoop()
+	return l
+}
+
+// Close 上报剩余的统计后停止
+func (l *statLoop) Close() {
+	close(l.stop)
+	<-l.die
+}
+
+func (l *statLoop) ioLoop() {
+	defer close(l.die)
+
+	ticker := time.NewTicker(l.interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			sc.reportStats()
-		case <-sc.stop:
-			sc.reportStats()
+			l.report()
+		case <-l.stop:
+			l.report()
 			return
 		}
 	}
 }
 
+func (l *statLoop) report() {
+	for _, sc := range l.collectors {
+		sc.reportStats()
+	}
+}
+
 func (sc *StatCollector) reportStats() {
 	sc.mu.Lock()
 	statsCopy := sc.stats
 	sc.stats = map[statKey]int64{}
 	sc.mu.Unlock()
 
+	var events []*Event
+	for key, count := range statsCopy {
+		events = append(events, sc.makeEvent(key, count))
+	}
+	if event := sc.makeThrottleEvent(); event != nil {
+		events = append(events, event)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	for key, count := range statsCopy {
-		event := sc.makeEvent(key.beginTime, key.beginTime.Add(sc.reportInterval), key.reportEvent, count)
-		data, err := event.transformToReportableData(sc.hostname)
+	for _, event := range events {
+		data, err := event.transformToReportableData(sc.hostname, nil)
 		if err != nil {
 			internal.DefaultLogger.Errorf("StatCollector reportStats transformToReportableData error: %s", err)
 			continue
@@ -99,23 +146,62 @@ func (sc *StatCollector) reportStats() {
 	}
 }
 
-func (sc *StatCollector) makeEvent(beginTime, endTime time.Time, event string, count int64) *Event {
+func (sc *StatCollector) makeEvent(key statKey, count int64) *Event {
+	props := sc.makeProps(key.beginTime, key.beginTime.Add(sc.reportInterval), key.reportEvent)
+	if key.discardReason == "" {
+		props["report_total"] = count
+	} else {
+		props["discard_reason"] = key.discardReason
+		props["discard_total"] = count
+	}
+	return &Event{
+		Name:  statsEventName,
+		Props: props,
+	}
+}
+
+// makeThrottleEvent 统计上次上报以来因限速而等待的请求数与时长，没有限速时返回 nil
+func (sc *StatCollector) makeThrottleEvent() *Event {
+	now := time.Now()
+	beginTime := sc.lastReportTime
+	sc.lastReportTime = now
+
+	rp, ok := sc.producer.(internal.RateLimitedProducer)
+	if !ok {
+		return nil
+	}
+	status := rp.ThrottleStatus()
+	last := sc.lastThrottle
+	sc.lastThrottle = status
+	if status.ThrottledTimes == last.ThrottledTimes {
+		return nil
+	}
+
+	props := sc.makeProps(beginTime, now, throttleReportEvent)
+	props["throttled_total"] = status.ThrottledTimes - last.ThrottledTimes
+	props["throttled_ms"] = (status.ThrottledDuration - last.ThrottledDuration).Milliseconds()
+	props["limit_bytes_per_second"] = status.Limit.BytesPerSecond
+	props["limit_requests_per_second"] = status.Limit.RequestsPerSecond
 	return &Event{
-		Name: statsEventName,
-		Props: map[string]any{
-			"instance_id":      sc.instanceID,
-			"client_mode":      string(sc.reportMode),
-			"access_key_id":    sc.accessKeyId,
-			"client_init_time": sc.initTime.UnixMilli(),
-			"begin_time":       beginTime.UnixMilli(),
-			"end_time":         endTime.UnixMilli(),
-			"report_event":     event,
-			"report_total":     count,
-		},
+		Name:  statsEventName,
+		Props: props,
+	}
+}
+
+func (sc *StatCollector) makeProps(beginTime, endTime time.Time, reportEvent string) map[string]any {
+	return map[string]any{
+		"instance_id":      sc.instanceID,
+		"client_mode":      string(sc.reportMode),
+		"access_key_id":    sc.accessKeyId,
+		"client_init_time": sc.initTime.UnixMilli(),
+		"begin_time":       beginTime.UnixMilli(),
+		"end_time":         endTime.UnixMilli(),
+		"report_event":     reportEvent,
 	}
 }
 
 type statKey struct {
-	reportEvent string
-	beginTime   time.Time
+	reportEvent   string
+	beginTime     time.Time
+	discardReason string // 非空时统计的是丢弃的数量
 }
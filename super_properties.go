@@ -0,0 +1,99 @@
+package funnydb
+
+import "context"
+
+// DynamicSuperPropertiesFunc 返回上报事件时添加的公共属性，e 为正在上报的事件，不要修改 e 以及返回的 map。
+// 每个事件都会调用一次，需要是并发安全且快速的
+type DynamicSuperPropertiesFunc func(e *Event) map[string]interface{}
+
+// SetSuperProperties 替换所有公共属性，props 会被复制
+func (c *Client) SetSuperProperties(props map[string]interface{}) {
+	copied := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		copied[k] = v
+	}
+	c.superMu.Lock()
+	c.superProps = copied
+	c.superMu.Unlock()
+}
+
+// SetSuperProperty 添加或修改一个公共属性
+func (c *Client) SetSuperProperty(key string, value interface{}) {
+	c.updateSuperProperties(func(props map[string]interface{}) {
+		props[key] = value
+	})
+}
+
+// UnsetSuperProperty 删除一个公共属性
+func (c *Client) UnsetSuperProperty(key string) {
+	c.updateSuperProperties(func(props map[string]interface{}) {
+		delete(props, key)
+	})
+}
+
+// SuperProperties 返回当前公共属性的副本
+func (c *Client) SuperProperties() map[string]interface{} {
+	c.superMu.RLock()
+	props := c.superProps
+	c.superMu.RUnlock()
+
+	copied := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		copied[k] = v
+	}
+	return copied
+}
+
+// SetDynamicSuperProperties 替换动态公共属性的回调，nil 表示不使用
+func (c *Client) SetDynamicSuperProperties(f DynamicSuperPropertiesFunc) {
+	c.superMu.Lock()
+	c.dynamicSuperProps = f
+	c.superMu.Unlock()
+}
+
+// updateSuperProperties 复制一份公共属性修改后整体替换，正在上报的事件不受影响
+func (c *Client) updateSuperProperties(update func(props map[string]interface{})) {
+	c.superMu.Lock()
+	defer c.superMu.Unlock()
+	copied := make(map[string]interface{}, len(c.superProps)+1)
+	for k, v := range c.superProps {
+		copied[k] = v
+	}
+	update(copied)
+	c.superProps = copied
+}
+
+// superPropsFor 返回事件在 Props 之外添加的属性，
+// 优先级从高到低为: ctx 中的属性、动态公共属性、公共属性
+func (c *Client) superPropsFor(ctx context.Context, e *Event) map[string]interface{} {
+	c.superMu.RLock()
+	static, dynamic := c.superProps, c.dynamicSuperProps
+	c.superMu.RUnlock()
+
+	layers := []map[string]interface{}{static}
+	if dynamic != nil {
+		layers = append(layers, dynamic(e))
+	}
+	layers = append(layers, c.contextProps(ctx))
+
+	// 只有一层有属性时不需要复制
+	var nonEmpty []map[string]interface{}
+	for _, props := range layers {
+		if len(props) > 0 {
+			nonEmpty = append(nonEmpty, props)
+		}
+	}
+	switch len(nonEmpty) {
+	case 0:
+		return nil
+	case 1:
+		return nonEmpty[0]
+	}
+	merged := make(map[string]interface{})
+	for _, props := range nonEmpty {
+		for k, v := range props {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,85 @@
+package funnydb
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_transformToReportableDataWithSuperProps(t *testing.T) {
+	props := map[string]interface{}{"#channel": "app_store", "level": 3}
+	e := &Event{Name: "UserLogin", Props: props}
+	superProps := map[string]interface{}{"#channel": "default", "region": "cn", internal.DataFieldNameEvent: "Other"}
+
+	data, err := e.transformToReportableData("localhost", superProps)
+	assert.Nil(t, err)
+	dataMap := data["data"].(map[string]interface{})
+	assert.Equal(t, "app_store", dataMap["#channel"])
+	assert.Equal(t, "cn", dataMap["region"])
+	assert.Equal(t, 3, dataMap["level"])
+	// SDK 字段不会被公共属性覆盖
+	assert.Equal(t, "UserLogin", dataMap[internal.DataFieldNameEvent])
+
+	// 公共属性不会写入调用方的 Props
+	assert.Equal(t, map[string]interface{}{"#channel": "app_store", "level": 3}, props)
+	assert.Len(t, superProps, 3)
+}
+
+func TestClientSuperProperties(t *testing.T) {
+	c, err := NewClient(&Config{
+		Mode:            ModeNoop,
+		SuperProperties: map[string]interface{}{"region": "cn", "shard": 1, "build": "1.0.0"},
+		DynamicSuperProperties: func(e *Event) map[string]interface{} {
+			return map[string]interface{}{"shard": 2, "dynamic_event": e.Name}
+		},
+	})
+	assert.Nil(t, err)
+	defer c.Close(context.Background())
+
+	e := &Event{Name: "UserLogin", Props: map[string]interface{}{"build": "1.0.1"}}
+	props := c.superPropsFor(context.Background(), e)
+	assert.Equal(t, map[string]interface{}{"region": "cn", "shard": 2, "build": "1.0.0", "dynamic_event": "UserLogin"}, props)
+	data, err := e.transformToReportableData("localhost", props)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0.1", data["data"].(map[string]interface{})["build"])
+
+	// 运行时修改
+	c.SetSuperProperty("region", "us")
+	c.UnsetSuperProperty("build")
+	c.SetDynamicSuperProperties(nil)
+	assert.Equal(t, map[string]interface{}{"region": "us", "shard": 1}, c.superPropsFor(context.Background(), e))
+	assert.Equal(t, map[string]interface{}{"region": "us", "shard": 1}, c.SuperProperties())
+
+	replaced := map[string]interface{}{"shard": 3}
+	c.SetSuperProperties(replaced)
+	replaced["shard"] = 4
+	assert.Equal(t, map[string]interface{}{"shard": 3}, c.SuperProperties())
+}
+
+func TestClientSuperPropertiesConcurrent(t *testing.T) {
+	c, err := NewClient(&Config{Mode: ModeNoop, SuperProperties: map[string]interface{}{"shard": 0}})
+	assert.Nil(t, err)
+	defer c.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.SetSuperProperty("shard", j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				err := c.ReportEvent(context.Background(), &Event{Name: "UserLogin", Props: map[string]interface{}{}})
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
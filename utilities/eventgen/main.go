@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	funnydb "github.com/funny/funnydb-go-sdk/v2"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+根据事件目录（YAML）生成强类型的事件构造函数，例如：
+
+	//go:generate go run github.com/funny/funnydb-go-sdk/v2/utilities/eventgen -input events.yaml -output events_gen.go -package events
+
+事件目录与 funnydb.LoadSchema 读取的 Schema 文件格式相同（只使用 events 部分），
+必须设置的属性按定义的顺序作为构造函数的参数，其余属性通过 With<事件><属性> 选项设置，
+字符串类型的 enum 生成对应的类型（<事件><属性>）与常量
+*/
+
+func main() {
+	input := flag.String("input", "", "event catalog file (YAML)")
+	output := flag.String("output", "", "output go file, default is <input>_gen.go")
+	pkg := flag.String("package", "", "package name of the output file, default is the name of the output directory")
+	flag.Parse()
+
+	if *input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(*input, filepath.Ext(*input)) + "_gen.go"
+	}
+	if *pkg == "" {
+		abs, err := filepath.Abs(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*pkg = filepath.Base(filepath.Dir(abs))
+	}
+	if err := run(*input, *output, *pkg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(input string, output string, pkg string) error {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	events, err := parseCatalog(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	code, err := generate(pkg, filepath.Base(input), events)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, code, 0644)
+}
+
+type catalogEvent struct {
+	Name  string
+	Props []catalogProp // 按定义的顺序
+}
+
+type catalogProp struct {
+	Name string
+	funnydb.PropSchema
+}
+
+// parseCatalog 读取事件目录，保留事件与属性定义的顺序
+func parseCatalog(data []byte) ([]catalogEvent, error) {
+	var doc struct {
+		Events yaml.Node `yaml:"events"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Events.Kind == 0 {
+		return nil, errors.New("no events defined")
+	}
+	if doc.Events.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: events must be a mapping", doc.Events.Line)
+	}
+
+	var events []catalogEvent
+	for i := 0; i < len(doc.Events.Content); i += 2 {
+		e := catalogEvent{Name: doc.Events.Content[i].Value}
+		var schema struct {
+			Props yaml.Node `yaml:"props"`
+		}
+		if err := doc.Events.Content[i+1].Decode(&schema); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		if schema.Props.Kind != 0 && schema.Props.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s: line %d: props must be a mapping", e.Name, schema.Props.Line)
+		}
+		for j := 0; j < len(schema.Props.Content); j += 2 {
+			p := catalogProp{Name: schema.Props.Content[j].Value}
+			if err := schema.Props.Content[j+1].Decode(&p.PropSchema); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", e.Name, p.Name, err)
+			}
+			if _, ok := goTypes[p.Type]; !ok {
+				return nil, fmt.Errorf("%s.%s: unknown type %s", e.Name, p.Name, p.Type)
+			}
+			e.Props = append(e.Props, p)
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// goTypes 属性类型对应的 Go 类型
+var goTypes = map[string]string{
+	"":                      "interface{}",
+	funnydb.PropTypeString:  "string",
+	funnydb.PropTypeInteger: "int64",
+	funnydb.PropTypeNumber:  "float64",
+	funnydb.PropTypeBoolean: "bool",
+	funnydb.PropTypeArray:   "[]interface{}",
+	funnydb.PropTypeObject:  "map[string]interface{}",
+}
+
+func generate(pkg string, source string, events []catalogEvent) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by eventgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "import funnydb %q\n", "github.com/funny/funnydb-go-sdk/v2")
+
+	idents := make(map[string]string) // 生成的标识符，避免不同的事件或属性转换后重名
+	declare := func(ident, owner string) error {
+		if prev, ok := idents[ident]; ok {
+			return fmt.Errorf("%s and %s both generate %s", prev, owner, ident)
+		}
+		idents[ident] = owner
+		return nil
+	}
+
+	for _, e := range events {
+		typeName := exportedName(e.Name)
+		if typeName == "" {
+			return nil, fmt.Errorf("event %q can not be converted to a go identifier", e.Name)
+		}
+		optionType := typeName + "Option"
+		if err := declare("New"+typeName, e.Name); err != nil {
+			return nil, err
+		}
+		if err := declare(optionType, e.Name); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\n// Event%s 事件名 %s\n", typeName, e.Name)
+		if err := declare("Event"+typeName, e.Name); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "const Event%s = %q\n", typeName, e.Name)
+
+		var params, required []string
+		var options bytes.Buffer
+		usedParams := map[string]bool{"props": true, "opts": true, "opt": true}
+		for _, p := range e.Props {
+			propName := exportedName(p.Name)
+			if propName == "" {
+				return nil, fmt.Errorf("%s.%s can not be converted to a go identifier", e.Name, p.Name)
+			}
+			goType := goTypes[p.Type]
+
+			// 字符串枚举生成单独的类型与常量，传入其他类型的变量时无法编译
+			isEnum := p.Type == funnydb.PropTypeString && len(p.Enum) > 0
+			if isEnum {
+				enumType := typeName + propName
+				if err := declare(enumType, e.Name+"."+p.Name); err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&b, "\n// %s %s.%s 的取值\ntype %s string\n", enumType, e.Name, p.Name, enumType)
+				var consts []string
+				for _, v := range p.Enum {
+					s, ok := v.(string)
+					if !ok || exportedName(s) == "" {
+						continue
+					}
+					ident := enumType + exportedName(s)
+					if err := declare(ident, e.Name+"."+p.Name); err != nil {
+						return nil, err
+					}
+					consts = append(consts, fmt.Sprintf("\t%s %s = %q\n", ident, enumType, s))
+				}
+				if len(consts) > 0 {
+					fmt.Fprintf(&b, "\nconst (\n%s)\n", strings.Join(consts, ""))
+				}
+				goType = enumType
+			}
+
+			if p.Required {
+				param := unexportedName(propName)
+				for usedParams[param] || token.IsKeyword(param) {
+					param += "Value"
+				}
+				usedParams[param] = true
+				params = append(params, fmt.Sprintf("%s %s", param, goType))
+				// 枚举上报时转换回 string，与 Schema 校验的类型一致
+				value := param
+				if isEnum {
+					value = "string(" + param + ")"
+				}
+				required = append(required, fmt.Sprintf("\t\t%q: %s,\n", p.Name, value))
+				continue
+			}
+
+			optionName := "With" + typeName + propName
+			if err := declare(optionName, e.Name+"."+p.Name); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&options, "\n// %s 设置 %s 的属性 %s%s\n", optionName, e.Name, p.Name, constraintComment(p.PropSchema))
+			value := "v"
+			if isEnum {
+				value = "string(v)"
+			}
+			fmt.Fprintf(&options, "func %s(v %s) %s {\n\treturn func(props map[string]interface{}) {\n\t\tprops[%q] = %s\n\t}\n}\n",
+				optionName, goType, optionType, p.Name, value)
+		}
+
+		fmt.Fprintf(&b, "\n// %s 设置 %s 的可选属性\ntype %s func(props map[string]interface{})\n", optionType, e.Name, optionType)
+		b.Write(options.Bytes())
+
+		fmt.Fprintf(&b, "\n// New%s 创建 %s 事件", typeName, e.Name)
+		if len(required) > 0 {
+			var names []string
+			for _, p := range e.Props {
+				if p.Required {
+					names = append(names, p.Name)
+				}
+			}
+			fmt.Fprintf(&b, "，参数依次为属性 %s", strings.Join(names, ", "))
+		}
+		b.WriteString("\n")
+		params = append(params, "opts ..."+optionType)
+		fmt.Fprintf(&b, "func New%s(%s) *funnydb.Event {\n", typeName, strings.Join(params, ", "))
+		fmt.Fprintf(&b, "\tprops := map[string]interface{}{\n%s\t}\n", strings.Join(required, ""))
+		b.WriteString("\tfor _, opt := range opts {\n\t\topt(props)\n\t}\n")
+		fmt.Fprintf(&b, "\treturn &funnydb.Event{Name: Event%s, Props: props}\n}\n", typeName)
+	}
+
+	code, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, b.String())
+	}
+	return code, nil
+}
+
+// constraintComment 属性约束的说明
+func constraintComment(p funnydb.PropSchema) string {
+	var parts []string
+	if len(p.Enum) > 0 {
+		var values []string
+		for _, v := range p.Enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		parts = append(parts, "取值 "+strings.Join(values, ", "))
+	}
+	if p.MaxLength > 0 {
+		parts = append(parts, "最大长度 "+strconv.Itoa(p.MaxLength))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "，" + strings.Join(parts, "，")
+}
+
+// commonInitialisms 转换为标识符时全部大写的单词
+var commonInitialisms = map[string]bool{
+	"ID": true, "IP": true, "URL": true, "UID": true, "UUID": true, "API": true, "OS": true, "CPU": true, "RAM": true, "SDK": true,
+}
+
+// exportedName 将事件名或属性名（例如 #account_id、user-login、UserLogin）转换为导出的标识符（AccountID、UserLogin）
+func exportedName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, w := range words {
+		if upper := strings.ToUpper(w); commonInitialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	s := b.String()
+	if s == "" {
+		return ""
+	}
+	if unicode.IsDigit([]rune(s)[0]) {
+		s = "X" + s
+	}
+	return s
+}
+
+// unexportedName 将导出的标识符转换为参数名，开头的缩写整体小写（AccountID -> accountID，IPAddress -> ipAddress）
+func unexportedName(ident string) string {
+	runes := []rune(ident)
+	n := 0
+	for n < len(runes) && unicode.IsUpper(runes[n]) {
+		n++
+	}
+	if n > 1 && n < len(runes) {
+		n-- // 最后一个大写字母属于下一个单词
+	}
+	for i := 0; i < n; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
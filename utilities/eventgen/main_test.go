@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames(t *testing.T) {
+	cases := []struct{ name, exported, param string }{
+		{"#account_id", "AccountID", "accountID"},
+		{"#ip", "IP", "ip"},
+		{"order_id", "OrderID", "orderID"},
+		{"UserLogin", "UserLogin", "userLogin"},
+		{"user-login", "UserLogin", "userLogin"},
+		{"id_card", "IDCard", "idCard"},
+		{"2fa", "X2fa", "x2fa"},
+		{"#", "", ""},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.exported, exportedName(tc.name), tc.name)
+		assert.Equal(t, tc.param, unexportedName(tc.exported), tc.name)
+	}
+}
+
+func TestParseCatalog(t *testing.T) {
+	events, err := parseCatalog([]byte(`
+events:
+  B:
+    props:
+      z: {type: string, required: true}
+      a: {type: integer, required: true}
+  A: {}
+`))
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "B", events[0].Name)
+	assert.Equal(t, "z", events[0].Props[0].Name)
+	assert.Equal(t, "a", events[0].Props[1].Name)
+	assert.Equal(t, "A", events[1].Name)
+
+	_, err = parseCatalog([]byte("events:\n  A:\n    props:\n      a: {type: date}\n"))
+	assert.ErrorContains(t, err, "unknown type date")
+	_, err = parseCatalog([]byte("mutations: {}\n"))
+	assert.ErrorContains(t, err, "no events defined")
+}
+
+func TestGenerate(t *testing.T) {
+	events, err := parseCatalog([]byte(`
+events:
+  Test:
+    props:
+      type: {type: string, required: true}
+      props: {required: true}
+`))
+	assert.Nil(t, err)
+	code, err := generate("events", "events.yaml", events)
+	assert.Nil(t, err)
+	assert.Contains(t, string(code), "func NewTest(typeValue string, propsValue interface{}, opts ...TestOption) *funnydb.Event")
+
+	// 字符串枚举使用单独的类型，上报时转换回 string
+	events, err = parseCatalog([]byte(`
+events:
+  Pay:
+    props:
+      channel: {type: string, required: true, enum: [app_store, "#"]}
+      currency: {type: string, enum: [CNY]}
+`))
+	assert.Nil(t, err)
+	code, err = generate("events", "events.yaml", events)
+	assert.Nil(t, err)
+	assert.Contains(t, string(code), "type PayChannel string\n")
+	assert.Contains(t, string(code), "PayChannelAppStore PayChannel = \"app_store\"")
+	assert.Contains(t, string(code), "func NewPay(channel PayChannel, opts ...PayOption) *funnydb.Event")
+	assert.Contains(t, string(code), "\"channel\": string(channel),")
+	assert.Contains(t, string(code), "func WithPayCurrency(v PayCurrency) PayOption")
+	assert.Contains(t, string(code), "props[\"currency\"] = string(v)")
+
+	// 转换后重名
+	events, err = parseCatalog([]byte("events:\n  user_login: {}\n  UserLogin: {}\n"))
+	assert.Nil(t, err)
+	_, err = generate("events", "events.yaml", events)
+	assert.ErrorContains(t, err, "both generate NewUserLogin")
+}
+
+// 示例中生成的代码与事件目录一致
+func TestGenerateExample(t *testing.T) {
+	dir := filepath.Join("..", "..", "example", "events")
+	output := filepath.Join(t.TempDir(), "events_gen.go")
+	assert.Nil(t, run(filepath.Join(dir, "events.yaml"), output, "events"))
+
+	want, err := os.ReadFile(filepath.Join(dir, "events_gen.go"))
+	assert.Nil(t, err)
+	got, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	assert.Equal(t, string(want), string(got))
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -11,26 +10,33 @@ import (
 	"log"
 	"os"
 	"time"
+
+	"github.com/funny/funnydb-go-sdk/v2/internal"
+	"github.com/funny/funnydb-go-sdk/v2/internal/diskqueue"
 )
 
 /*
-提取 Async 模式存储在本地磁盘的数据，转换为 json 打印到标准输出
+提取 Async 模式存储在本地磁盘的数据（或 PersistOnly 模式的日志文件），转换为 json 打印到标准输出
+
+加密的数据需要通过 -key-file 指定写入时使用的密钥文件
 */
 
 func main() {
 	inputFile := flag.String("input", "", "input file")
 	skipBeforeStr := flag.String("skip-before", "", "skip message before certain timestamp (format: 2006-01-02T15:04:05Z07:00)")
 	skipAfterStr := flag.String("skip-after", "", "skip message after certain timestamp (format: 2006-01-02T15:04:05Z07:00)")
+	keyFile := flag.String("key-file", "", "key file for encrypted data, each line is \"<id> <base64 key>\"")
+	isLog := flag.Bool("log", false, "input is a persist_only log file instead of an async queue file")
 
 	flag.Parse()
 
 	log.Println("read file", "input_file", *inputFile)
-	if err := run(*inputFile, *skipBeforeStr, *skipAfterStr); err != nil {
+	if err := run(*inputFile, *skipBeforeStr, *skipAfterStr, *keyFile, *isLog); err != nil {
 		panic(err)
 	}
 }
 
-func run(inputFile string, skipBeforeStr string, skipAfterStr string) error {
+func run(inputFile string, skipBeforeStr string, skipAfterStr string, keyFile string, isLog bool) error {
 	var (
 		skipBefore time.Time
 		skipAfter  time.Time
@@ -50,44 +56,62 @@ func run(inputFile string, skipBeforeStr string, skipAfterStr string) error {
 		}
 	}
 
+	var recordCipher *internal.RecordCipher
+	if keyFile != "" {
+		provider, err := internal.NewKeyFileProvider(keyFile)
+		if err != nil {
+			return fmt.Errorf("load key file: %s", err)
+		}
+		recordCipher = internal.NewRecordCipher(provider)
+	}
+
 	f, err := os.Open(inputFile)
 	if err != nil {
 		return fmt.Errorf("open file: %s", err)
 	}
 	defer f.Close()
 
-	reader := bufio.NewReader(f)
-
-	var msgSize int32
+	var next func() ([]byte, error)
+	if isLog {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 20*1024*1024)
+		next = func() ([]byte, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			return internal.DecodeLogLine(recordCipher, scanner.Bytes())
+		}
+	} else {
+		reader := diskqueue.NewReader(f, 10*1024*1024)
+		if recordCipher != nil {
+			reader.SetCipher(recordCipher)
+		}
+		next = func() ([]byte, error) {
+			readBuf, err := reader.Next()
+			if err != nil {
+				return nil, err
+			}
+			return internal.QueueRecordToJSON(readBuf)
+		}
+	}
 
 	readCount := 0
 	skipCount := 0
 
 	for {
-		err = binary.Read(reader, binary.BigEndian, &msgSize)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return fmt.Errorf("get next message size: %s", err)
-		}
-
-		// check msg is in reasonable size
-		if !(0 < msgSize && msgSize <= 10*1024*1024) {
-			return fmt.Errorf("possible file corruption: msg size is abow 10MB or a negative number")
-		}
-
-		readBuf := make([]byte, msgSize)
-		_, err = io.ReadFull(reader, readBuf)
+		readBuf, err := next()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return fmt.Errorf("failed to read msg: %s", err)
+			return fmt.Errorf("possible file corruption: %s", err)
 		}
 
-		if readBuf[0] != '{' || readBuf[len(readBuf)-1] != '}' {
-			return fmt.Errorf("possible file corruption: malformed msg: %s", string(readBuf))
+		if len(readBuf) < 2 || readBuf[0] != '{' || readBuf[len(readBuf)-1] != '}' {
+			return fmt.Errorf("possible file corruption: malformed msg: %q", readBuf)
 		}
 
 		readCount += 1